@@ -0,0 +1,88 @@
+package requestbody
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AcceptEncoding is a content-coding listed in an Accept-Encoding header, with its quality value.
+type AcceptEncoding struct {
+	// Coding is the lowercase content-coding, which may be "*" to match any coding not otherwise listed.
+	Coding string
+	// Quality is the relative preference for the coding from 0 to 1, where 0 means "not acceptable".
+	Quality float64
+}
+
+// ParseAcceptEncoding parses an Accept-Encoding header, returning the listed codings ordered from the
+// most to least preferred. Codings without a quality value have a quality of 1, and codings with an
+// invalid quality value are skipped.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9110.html#name-accept-encoding
+func ParseAcceptEncoding(header string) []AcceptEncoding {
+	var accepted []AcceptEncoding
+	for _, element := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(element, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				continue
+			}
+			quality = q
+		}
+		accepted = append(accepted, AcceptEncoding{Coding: coding, Quality: quality})
+	}
+	slices.SortStableFunc(accepted, func(a, b AcceptEncoding) int {
+		switch {
+		case a.Quality > b.Quality:
+			return -1
+		case a.Quality < b.Quality:
+			return 1
+		}
+		return 0
+	})
+	return accepted
+}
+
+// NegotiateEncodings returns the supported codings which are acceptable according to an Accept-Encoding
+// header, ordered from the most to least preferred. A "*" in the header is expanded to the supported codings
+// which aren't otherwise listed, rather than being echoed back, and codings with a quality of 0 are excluded.
+func NegotiateEncodings(header string, supported []string) []string {
+	accepted := ParseAcceptEncoding(header)
+	listed := make(map[string]bool, len(accepted))
+	for _, entry := range accepted {
+		listed[entry.Coding] = true
+	}
+	negotiated := []string{}
+	for _, entry := range accepted {
+		if entry.Quality == 0 {
+			continue
+		}
+		for _, coding := range supported {
+			matches := entry.Coding == coding || (entry.Coding == "*" && !listed[coding])
+			if matches && !slices.Contains(negotiated, coding) {
+				negotiated = append(negotiated, coding)
+			}
+		}
+	}
+	return negotiated
+}
+
+// NegotiateOptionsAdvertise limits the encodings advertised in the Accept-Encoding header of OPTIONS
+// responses to those acceptable according to the request's own Accept-Encoding header, using
+// NegotiateEncodings. A client probing with "Accept-Encoding: *" receives the concrete list of
+// advertised encodings. Requests without an Accept-Encoding header are advertised all encodings.
+// This is disabled by default.
+// This option only has an effect when passed to RequestBodyHandler.
+func NegotiateOptionsAdvertise(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.negotiateOptionsAdvertise = enable
+		},
+	}
+}
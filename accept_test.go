@@ -0,0 +1,67 @@
+package requestbody
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAcceptEncoding(t *testing.T) {
+	t.Parallel()
+
+	assertEqual(t, []AcceptEncoding{
+		{Coding: "br", Quality: 1},
+		{Coding: "*", Quality: 1},
+		{Coding: "gzip", Quality: 0.8},
+		{Coding: "identity", Quality: 0},
+	}, ParseAcceptEncoding("gzip;q=0.8, BR, identity; q=0, , *, deflate;q=2, zstd;q=x"))
+	assertEqual(t, []AcceptEncoding(nil), ParseAcceptEncoding(""))
+}
+
+func TestNegotiateEncodings(t *testing.T) {
+	t.Parallel()
+
+	supported := []string{"br", "deflate", "gzip", "zstd"}
+	for _, test := range []struct {
+		header   string
+		expected []string
+	}{
+		{"*", []string{"br", "deflate", "gzip", "zstd"}},
+		{"gzip, br;q=0.5", []string{"gzip", "br"}},
+		{"gzip;q=0.5, *", []string{"br", "deflate", "zstd", "gzip"}},
+		{"*, br;q=0", []string{"deflate", "gzip", "zstd"}},
+		{"compress", []string{}},
+	} {
+		assertEqual(t, test.expected, NegotiateEncodings(test.header, supported))
+	}
+}
+
+func TestNegotiateOptionsAdvertise(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{"wildcard", "*", strings.Join(defaultEncodings(), ", ")},
+		{"wildcard with strict encodings", "*, x-gzip", strings.Join(defaultEncodings(), ", ")},
+		{"subset", "gzip, deflate;q=0.5, compress", "gzip, deflate"},
+		{"no header", "", strings.Join(defaultEncodings(), ", ")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), NegotiateOptionsAdvertise(true), StrictAdvertisedEncodings(true))
+			req := httptest.NewRequest(http.MethodOptions, "/", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept-Encoding", test.accept)
+			}
+			response := httptest.NewRecorder()
+
+			handler.ServeHTTP(response, req)
+
+			assertEqual(t, test.expected, response.Header().Get("Accept-Encoding"))
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package requestbody
+
+import (
+	"bufio"
+	"encoding/ascii85"
+	"io"
+)
+
+// Ascii85EncodingReader decodes an ascii85 (base85) encoded body, for clients which encode
+// binary payloads to send them over text-only channels. It isn't supported by default, but can be
+// registered using SupportEncoding("ascii85", Ascii85EncodingReader).
+//
+// The optional "<~" and "~>" framing delimiters are tolerated, and any data after the closing
+// delimiter is ignored.
+func Ascii85EncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(ascii85.NewDecoder(&ascii85Framing{reader: bufio.NewReader(r)})), nil
+}
+
+// ascii85Framing strips the "<~" and "~>" delimiters which encoding/ascii85 doesn't understand.
+type ascii85Framing struct {
+	reader  *bufio.Reader
+	started bool
+	done    bool
+}
+
+func (f *ascii85Framing) Read(p []byte) (int, error) {
+	if f.done {
+		return 0, io.EOF
+	}
+	if !f.started {
+		f.started = true
+		// Skip leading whitespace so we can detect the opening delimiter.
+		for {
+			next, err := f.reader.Peek(1)
+			if err != nil {
+				return 0, err
+			}
+			if !isAscii85Space(next[0]) {
+				break
+			}
+			_, _ = f.reader.Discard(1)
+		}
+		if prefix, _ := f.reader.Peek(2); string(prefix) == "<~" {
+			_, _ = f.reader.Discard(2)
+		}
+	}
+
+	n := 0
+	for n < len(p) {
+		b, err := f.reader.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if b == '~' {
+			if next, _ := f.reader.Peek(1); len(next) == 1 && next[0] == '>' {
+				f.done = true
+				if n == 0 {
+					return 0, io.EOF
+				}
+				return n, nil
+			}
+			// A lone '~' is passed through so the decoder reports it as invalid.
+		}
+		p[n] = b
+		n++
+		if f.reader.Buffered() == 0 {
+			// Return what we have rather than blocking for more input.
+			break
+		}
+	}
+	return n, nil
+}
+
+func isAscii85Space(b byte) bool {
+	switch b {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package requestbody
+
+import (
+	"bytes"
+	"encoding/ascii85"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestAscii85EncodingReader(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog\x00\x01\x02")
+	encoded := make([]byte, ascii85.MaxEncodedLen(len(sourceData)))
+	encoded = encoded[:ascii85.Encode(encoded, sourceData)]
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncoding("ascii85", Ascii85EncodingReader))
+
+		response := postEncoded(t, ts, "ascii85", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("round trip with framing", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncoding("ascii85", Ascii85EncodingReader))
+		framed := append(append([]byte("\n<~"), encoded...), []byte("~>\n")...)
+
+		response := postEncoded(t, ts, "ascii85", framed)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		t.Parallel()
+
+		reader, err := Ascii85EncodingReader(bytes.NewReader([]byte("<~abc~def~>")))
+		assertNoError(t, err)
+		_, err = io.ReadAll(reader)
+		if err == nil {
+			t.Errorf("Expected an error decoding invalid ascii85 data")
+		}
+	})
+}
+
+func ascii85Bytes(data []byte) []byte {
+	encoded := make([]byte, ascii85.MaxEncodedLen(len(data)))
+	return encoded[:ascii85.Encode(encoded, data)]
+}
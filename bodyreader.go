@@ -0,0 +1,40 @@
+package requestbody
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// NewBodyReader returns a reader which decodes and limits the body in the same way as RequestBodyHandler,
+// for bodies which don't arrive through the middleware, such as a request read from a queue or a test fixture.
+// The header provides the Content-Encoding and Content-Type, and contentLength is the Content-Length of the
+// raw body, or -1 if unknown.
+//
+// Errors are always returned from Read, as if using ReturnOnError, so any error handler in the options is ignored.
+// Checks which need the whole request, such as AntiSmuggling, aren't applied.
+func NewBodyReader(body io.ReadCloser, header http.Header, contentLength int64, opts ...Option) io.ReadCloser {
+	options := newOptions(opts)
+	options.handleError = nil
+	options.kindErrorHandlers = nil
+	if header == nil {
+		header = http.Header{}
+	}
+
+	lazyBody := &lazyReader{
+		reader:          body,
+		contentLength:   contentLength,
+		contentEncoding: contentEncodingHeader(header),
+		contentType:     header.Get("Content-Type"),
+		options:         options,
+	}
+	// Callbacks and encoding readers receive a request, so build one to carry the body and headers.
+	request := &http.Request{
+		Method:        http.MethodPost,
+		Header:        header,
+		ContentLength: contentLength,
+		Body:          lazyBody,
+	}
+	lazyBody.request = request.WithContext(context.WithValue(context.Background(), contextKey, lazyBody))
+	return lazyBody
+}
@@ -0,0 +1,118 @@
+package requestbody
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func ExampleNewBodyReader() {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte("hello, world"))
+	_ = writer.Close()
+
+	header := http.Header{"Content-Encoding": {"gzip"}}
+	body := NewBodyReader(io.NopCloser(&compressed), header, int64(compressed.Len()))
+	defer body.Close()
+
+	decoded, err := io.ReadAll(body)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(string(decoded))
+	// Output: hello, world
+}
+
+func TestNewBodyReader(t *testing.T) {
+	t.Parallel()
+
+	t.Run("identity body", func(t *testing.T) {
+		t.Parallel()
+		body := NewBodyReader(io.NopCloser(strings.NewReader("plain")), nil, 5)
+		got, err := io.ReadAll(body)
+		assertNoError(t, err)
+		assertEqual(t, "plain", string(got))
+		assertNoError(t, body.Close())
+	})
+
+	t.Run("returns content too large", func(t *testing.T) {
+		t.Parallel()
+		body := NewBodyReader(io.NopCloser(strings.NewReader("too long")), nil, 8, ContentLengthLimit(4))
+		_, err := io.ReadAll(body)
+		var tooLarge *RequestContentTooLargeError
+		if !errors.As(err, &tooLarge) {
+			t.Fatalf("expected RequestContentTooLargeError, got %v", err)
+		}
+		assertEqual(t, int64(4), tooLarge.Limit)
+	})
+
+	t.Run("returns decoded content too large", func(t *testing.T) {
+		t.Parallel()
+		compressed := gzipBytes(t, []byte(strings.Repeat("a", 100)))
+		header := http.Header{"Content-Encoding": {"gzip"}}
+		body := NewBodyReader(io.NopCloser(bytes.NewReader(compressed)), header, -1, ContentLengthLimit(50))
+		_, err := io.ReadAll(body)
+		var tooLarge *RequestContentTooLargeError
+		if !errors.As(err, &tooLarge) {
+			t.Fatalf("expected RequestContentTooLargeError, got %v", err)
+		}
+	})
+
+	t.Run("returns unsupported encoding", func(t *testing.T) {
+		t.Parallel()
+		header := http.Header{"Content-Encoding": {"unknown"}}
+		body := NewBodyReader(io.NopCloser(strings.NewReader("data")), header, 4)
+		_, err := io.ReadAll(body)
+		var unsupported *RequestUnsupportedMediaTypeError
+		if !errors.As(err, &unsupported) {
+			t.Fatalf("expected RequestUnsupportedMediaTypeError, got %v", err)
+		}
+		assertEqual(t, "unknown", unsupported.Encoding)
+		if closeErr := body.Close(); !errors.As(closeErr, &unsupported) {
+			t.Fatalf("expected RequestUnsupportedMediaTypeError from Close, got %v", closeErr)
+		}
+	})
+
+	t.Run("ignores error handler", func(t *testing.T) {
+		t.Parallel()
+		called := false
+		handler := HandleRequestBodyError(func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+			called = true
+		})
+		body := NewBodyReader(io.NopCloser(strings.NewReader("corrupt")), http.Header{"Content-Encoding": {"gzip"}}, 7, handler)
+		_, err := io.ReadAll(body)
+		var badRequest *BadRequestError
+		if !errors.As(err, &badRequest) {
+			t.Fatalf("expected BadRequestError, got %v", err)
+		}
+		assertEqual(t, false, called)
+	})
+
+	t.Run("requires content length", func(t *testing.T) {
+		t.Parallel()
+		body := NewBodyReader(io.NopCloser(strings.NewReader("data")), nil, -1, RequireContentLength(true))
+		_, err := io.ReadAll(body)
+		var lengthRequired *RequestContentLengthRequiredError
+		if !errors.As(err, &lengthRequired) {
+			t.Fatalf("expected RequestContentLengthRequiredError, got %v", err)
+		}
+	})
+
+	t.Run("accessors use the reader's request", func(t *testing.T) {
+		t.Parallel()
+		var read int64
+		body := NewBodyReader(io.NopCloser(strings.NewReader("data")), nil, 4, OnBodyComplete(func(r *http.Request, bytesRead int64, encoding string) {
+			read = BytesRead(r)
+		}))
+		_, err := io.ReadAll(body)
+		assertNoError(t, err)
+		assertEqual(t, int64(4), read)
+	})
+}
@@ -0,0 +1,24 @@
+//go:build !requestbody_nobrotli
+
+package requestbody
+
+import (
+	"io"
+
+	"github.com/andybalholm/brotli"
+)
+
+// brotliAvailable reports whether the Brotli decoder is compiled in, which can be disabled using
+// the requestbody_nobrotli build tag.
+const brotliAvailable = true
+
+// BrotliEncodingReader decodes a Brotli ("br") encoded body. It's supported by default.
+//
+// A Brotli stream has no concatenated frames, so any data after the end of the stream always
+// returns an error regardless of the MultiFrame option.
+//
+// Shared dictionaries (RFC 9842) aren't supported, as the Brotli decoder only supports the
+// built-in static dictionary. Bodies using the "dcb" content-coding are rejected as unsupported.
+func BrotliEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
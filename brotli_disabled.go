@@ -0,0 +1,17 @@
+//go:build requestbody_nobrotli
+
+package requestbody
+
+import (
+	"io"
+)
+
+// brotliAvailable reports whether the Brotli decoder is compiled in, which has been disabled using
+// the requestbody_nobrotli build tag.
+const brotliAvailable = false
+
+// BrotliEncodingReader rejects Brotli ("br") encoded bodies with a RequestUnsupportedMediaTypeError,
+// as the decoder was excluded using the requestbody_nobrotli build tag.
+func BrotliEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, unavailableEncodingError("br")
+}
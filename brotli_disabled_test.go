@@ -0,0 +1,42 @@
+//go:build requestbody_nobrotli
+
+package requestbody
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBrotliEncodingReaderDisabled(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unsupported by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "br", []byte("not decoded"))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+		assertEqual(t, false, strings.Contains(response.Header.Get("Accept-Encoding"), "br"))
+	})
+
+	t.Run("reader error", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := BrotliEncodingReader(strings.NewReader("not decoded"))
+
+		var unsupported *RequestUnsupportedMediaTypeError
+		assertEqual(t, true, errors.As(err, &unsupported))
+		assertEqual(t, "br", unsupported.Encoding)
+	})
+}
+
+// brotliBytes returns the data as-is, as the encoder isn't built with the decoder excluded.
+// It's only used for bodies which are rejected or passed through without decoding.
+func brotliBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	return data
+}
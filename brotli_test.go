@@ -0,0 +1,85 @@
+//go:build !requestbody_nobrotli
+
+package requestbody
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestBrotliEncodingReader(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("supported by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "br", brotliBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("chained with gzip", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "br, gzip", gzipBytes(t, brotliBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("trailing data", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+		encoded := brotliBytes(t, sourceData)
+
+		response := postEncoded(t, ts, "br", append(encoded, encoded...))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "br", []byte("not brotli"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("shared dictionary unsupported", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "dcb", brotliBytes(t, sourceData))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), DisableEncoding("br"))
+
+		response := postEncoded(t, ts, "br", brotliBytes(t, sourceData))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+}
+
+func brotliBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	br := brotli.NewWriter(&buf)
+	_, err := br.Write(data)
+	assertNoError(t, err)
+	assertNoError(t, br.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,189 @@
+package requestbody
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BudgetStore records the number of raw body bytes received from each client, for use with the
+// ClientBudget option. Implementations must be safe for concurrent use, and can share state between
+// servers, such as by using Redis.
+type BudgetStore interface {
+	// Add adds n bytes to the total for the key within the current window, starting a new window
+	// if there isn't one, and returns the updated total.
+	Add(key string, n int64, window time.Duration) (total int64, err error)
+}
+
+// RequestBudgetExceededError is returned when a client has sent more than the ClientBudget limit within
+// the budget window. The recommended status code for this error is 429 Too Many Requests.
+//
+// If the BudgetStore failed, and BudgetStoreFailOpen isn't enabled, Err is the store error and the
+// recommended status code is 503 Service Unavailable instead.
+//
+// See: https://www.rfc-editor.org/rfc/rfc6585.html#section-4
+type RequestBudgetExceededError struct {
+	Limit  int64
+	Window time.Duration
+	Err    error
+}
+
+func (e *RequestBudgetExceededError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("Service Unavailable: checking client budget: %v", e.Err)
+	}
+	return fmt.Sprintf("Too Many Requests: more than %d bytes within %v", e.Limit, e.Window)
+}
+func (e *RequestBudgetExceededError) RecommendedStatusCode() int {
+	if e.Err != nil {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusTooManyRequests
+}
+func (e *RequestBudgetExceededError) Unwrap() error {
+	return e.Err
+}
+
+// ClientBudget limits the raw body bytes each client can send within the window, across all of its requests.
+// Clients are identified using the key function, or by the host of the remote address if key is nil.
+// Requests with an empty key aren't limited.
+// The declared content length is counted before reading, while bodies of unknown length are counted as they're read.
+// If the budget is exceeded, a RequestBudgetExceededError will be returned.
+// This is disabled by default, or when the limit or window is set to zero or less.
+func ClientBudget(limit int64, window time.Duration, key func(r *http.Request) string) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.clientBudget = limit
+			opts.clientBudgetWindow = window
+			opts.clientBudgetKey = key
+		},
+	}
+}
+
+// WithBudgetStore sets the store used to record client budgets for the ClientBudget option.
+// By default, each RequestBodyHandler records budgets in memory.
+func WithBudgetStore(store BudgetStore) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.budgetStore = store
+		},
+	}
+}
+
+// BudgetStoreFailOpen allows requests when the BudgetStore returns an error, rather than returning a
+// RequestBudgetExceededError with the store error.
+// This is disabled by default, so requests fail closed.
+func BudgetStoreFailOpen(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.budgetStoreFailOpen = enable
+		},
+	}
+}
+
+// NewMemoryBudgetStore returns a BudgetStore which records budgets in memory using fixed windows.
+// This can be used to share budgets between multiple handlers in the same process.
+func NewMemoryBudgetStore() BudgetStore {
+	return &memoryBudgetStore{entries: map[string]budgetEntry{}, now: time.Now}
+}
+
+type budgetEntry struct {
+	total   int64
+	expires time.Time
+}
+
+type memoryBudgetStore struct {
+	mu        sync.Mutex
+	entries   map[string]budgetEntry
+	nextSweep time.Time
+	now       func() time.Time
+}
+
+func (s *memoryBudgetStore) Add(key string, n int64, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if !now.Before(s.nextSweep) {
+		// Remove expired windows so clients which have gone away don't use memory forever.
+		for k, entry := range s.entries {
+			if !now.Before(entry.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextSweep = now.Add(window)
+	}
+	entry, ok := s.entries[key]
+	if !ok || !now.Before(entry.expires) {
+		entry = budgetEntry{expires: now.Add(window)}
+	}
+	entry.total += n
+	s.entries[key] = entry
+	return entry.total, nil
+}
+
+// clientBudget charges a single client's bytes to the budget store.
+type clientBudget struct {
+	key      string
+	limit    int64
+	window   time.Duration
+	store    BudgetStore
+	failOpen bool
+}
+
+// newClientBudget returns nil if the request isn't limited.
+func (r *lazyReader) newClientBudget() *clientBudget {
+	if r.options.clientBudget <= 0 || r.options.clientBudgetWindow <= 0 || r.options.budgetStore == nil {
+		return nil
+	}
+	var key string
+	if r.options.clientBudgetKey != nil {
+		key = r.options.clientBudgetKey(r.request)
+	} else if host, _, err := net.SplitHostPort(r.request.RemoteAddr); err == nil {
+		key = host
+	} else {
+		key = r.request.RemoteAddr
+	}
+	if key == "" {
+		return nil
+	}
+	return &clientBudget{
+		key:      key,
+		limit:    r.options.clientBudget,
+		window:   r.options.clientBudgetWindow,
+		store:    r.options.budgetStore,
+		failOpen: r.options.budgetStoreFailOpen,
+	}
+}
+
+func (b *clientBudget) charge(n int64) RequestBodyError {
+	total, err := b.store.Add(b.key, n, b.window)
+	if err != nil {
+		if b.failOpen {
+			return nil
+		}
+		return &RequestBudgetExceededError{Limit: b.limit, Window: b.window, Err: err}
+	}
+	if total > b.limit {
+		return &RequestBudgetExceededError{Limit: b.limit, Window: b.window}
+	}
+	return nil
+}
+
+// clientBudgetReader charges raw bytes to the client budget as they're read.
+type clientBudgetReader struct {
+	io.ReadCloser
+	budget *clientBudget
+}
+
+func (c *clientBudgetReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		if budgetErr := c.budget.charge(int64(n)); budgetErr != nil {
+			return n, budgetErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,179 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRemoteStore simulates a shared store which may be unavailable.
+type fakeRemoteStore struct {
+	mu     sync.Mutex
+	totals map[string]int64
+	keys   []string
+	err    error
+}
+
+func (f *fakeRemoteStore) Add(key string, n int64, window time.Duration) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = append(f.keys, key)
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.totals == nil {
+		f.totals = map[string]int64{}
+	}
+	f.totals[key] += n
+	return f.totals[key], nil
+}
+
+func TestMemoryBudgetStore(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	store := &memoryBudgetStore{entries: map[string]budgetEntry{}, now: func() time.Time { return now }}
+
+	total, err := store.Add("a", 10, time.Minute)
+	assertNoError(t, err)
+	assertEqual(t, int64(10), total)
+	total, _ = store.Add("a", 5, time.Minute)
+	assertEqual(t, int64(15), total)
+	total, _ = store.Add("b", 1, time.Minute)
+	assertEqual(t, int64(1), total)
+
+	// A new window starts once the previous one expires.
+	now = now.Add(time.Minute)
+	total, _ = store.Add("a", 3, time.Minute)
+	assertEqual(t, int64(3), total)
+	if _, ok := store.entries["b"]; ok {
+		t.Errorf("Expected the expired window for b to be removed")
+	}
+}
+
+func TestClientBudget(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	newHandler := func(opts ...Option) (http.Handler, chan error) {
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		return RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...), errs
+	}
+	post := func(handler http.Handler, remoteAddr string, body io.Reader) {
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.RemoteAddr = remoteAddr
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("declared length", func(t *testing.T) {
+		t.Parallel()
+		handler, errs := newHandler(ClientBudget(int64(len(sourceData))*2, time.Minute, nil))
+		post(handler, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		assertNoError(t, <-errs)
+		post(handler, "192.0.2.1:5678", bytes.NewReader(sourceData))
+		assertNoError(t, <-errs)
+
+		post(handler, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		var budgetErr *RequestBudgetExceededError
+		assertEqual(t, true, errors.As(<-errs, &budgetErr))
+		assertEqual(t, http.StatusTooManyRequests, budgetErr.RecommendedStatusCode())
+		assertEqual(t, int64(len(sourceData))*2, budgetErr.Limit)
+
+		// Other clients have their own budget.
+		post(handler, "192.0.2.2:1234", bytes.NewReader(sourceData))
+		assertNoError(t, <-errs)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		t.Parallel()
+		handler, errs := newHandler(ClientBudget(int64(len(sourceData))+5, time.Minute, nil))
+		post(handler, "192.0.2.1:1234", io.MultiReader(bytes.NewReader(sourceData)))
+		assertNoError(t, <-errs)
+
+		post(handler, "192.0.2.1:1234", io.MultiReader(bytes.NewReader(sourceData)))
+		var budgetErr *RequestBudgetExceededError
+		assertEqual(t, true, errors.As(<-errs, &budgetErr))
+	})
+
+	t.Run("status code", func(t *testing.T) {
+		t.Parallel()
+		handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), ClientBudget(int64(len(sourceData)), time.Minute, nil))
+		for _, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			assertEqual(t, expected, recorder.Code)
+		}
+	})
+
+	t.Run("key function", func(t *testing.T) {
+		t.Parallel()
+		store := &fakeRemoteStore{}
+		key := func(r *http.Request) string { return r.Header.Get("X-Api-Key") }
+		handler, errs := newHandler(ClientBudget(1024, time.Minute, key), WithBudgetStore(store))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+		req.Header.Set("X-Api-Key", "client-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assertNoError(t, <-errs)
+
+		// Requests without a key aren't limited.
+		post(handler, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		assertNoError(t, <-errs)
+		assertEqual(t, "client-1", strings.Join(store.keys, ","))
+		assertEqual(t, int64(len(sourceData)), store.totals["client-1"])
+	})
+
+	t.Run("remote store", func(t *testing.T) {
+		t.Parallel()
+		store := &fakeRemoteStore{}
+		// Separate handlers share the budget through the store.
+		first, firstErrs := newHandler(ClientBudget(int64(len(sourceData)), time.Minute, nil), WithBudgetStore(store))
+		second, secondErrs := newHandler(ClientBudget(int64(len(sourceData)), time.Minute, nil), WithBudgetStore(store))
+		post(first, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		assertNoError(t, <-firstErrs)
+
+		post(second, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		var budgetErr *RequestBudgetExceededError
+		assertEqual(t, true, errors.As(<-secondErrs, &budgetErr))
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		t.Parallel()
+		storeErr := errors.New("connection refused")
+		handler, errs := newHandler(ClientBudget(1024, time.Minute, nil), WithBudgetStore(&fakeRemoteStore{err: storeErr}))
+		post(handler, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		err := <-errs
+		var budgetErr *RequestBudgetExceededError
+		assertEqual(t, true, errors.As(err, &budgetErr))
+		assertEqual(t, http.StatusServiceUnavailable, budgetErr.RecommendedStatusCode())
+		assertEqual(t, true, errors.Is(err, storeErr))
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		t.Parallel()
+		store := &fakeRemoteStore{err: errors.New("connection refused")}
+		handler, errs := newHandler(ClientBudget(1024, time.Minute, nil), WithBudgetStore(store), BudgetStoreFailOpen(true))
+		post(handler, "192.0.2.1:1234", io.MultiReader(bytes.NewReader(sourceData)))
+		assertNoError(t, <-errs)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		store := &fakeRemoteStore{}
+		handler, errs := newHandler(WithBudgetStore(store))
+		post(handler, "192.0.2.1:1234", bytes.NewReader(sourceData))
+		assertNoError(t, <-errs)
+		assertEqual(t, 0, len(store.keys))
+	})
+}
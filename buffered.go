@@ -0,0 +1,101 @@
+package requestbody
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// BufioBody returns a buffered reader over the decoded and limited request body, for handlers doing
+// line-based parsing. Errors are handled in the same way as when reading from the body directly.
+// The size is the minimum buffer size, or the bufio default when zero or less.
+func BufioBody(r *http.Request, size int) *bufio.Reader {
+	if size <= 0 {
+		return bufio.NewReader(r.Body)
+	}
+	return bufio.NewReaderSize(r.Body, size)
+}
+
+// EachLine calls fn with each line of the decoded and limited request body, such as for NDJSON, without the
+// trailing "\n" or "\r\n". The line is only valid until fn returns, as the buffer is reused for the next line.
+// Reading stops at the first error returned by fn, which is returned as-is.
+//
+// Lines longer than maxLineLen return a BadRequestError without reading the rest of the line, while a
+// maxLineLen of zero or less allows lines of any length, limited only by the body limits.
+// The line buffers count towards the MaxTotalBufferBytes limit for the request while reading.
+// Errors are handled in the same way as when reading from the body directly.
+func EachLine(r *http.Request, fn func(line []byte) error, maxLineLen int) error {
+	handleBodyError := func(err error) error { return err }
+	body, ok := bodyFromRequest(r)
+	if ok {
+		handleBodyError = body.handleError
+	}
+
+	var reader *bufio.Reader
+	if maxLineLen > 0 {
+		reader = bufio.NewReaderSize(r.Body, maxLineLen+len("\r\n"))
+	} else {
+		reader = bufio.NewReader(r.Body)
+	}
+	// The buffers are released once reading stops, as they're reused for each line.
+	var buffered int64
+	defer func() { body.releaseBuffer(buffered) }()
+	charge := func(n int) error {
+		if err := body.chargeBuffer(int64(n)); err != nil {
+			return handleBodyError(err)
+		}
+		buffered += int64(n)
+		return nil
+	}
+	if err := charge(reader.Size()); err != nil {
+		return err
+	}
+	tooLong := func() error {
+		return handleBodyError(&BadRequestError{
+			Err: fmt.Errorf("line exceeds the maximum length of %d bytes", maxLineLen),
+		})
+	}
+	// long holds lines which don't fit in the reader's buffer when the length is unlimited.
+	var long []byte
+	grow := func(line []byte) error {
+		previous := cap(long)
+		long = append(long, line...)
+		return charge(cap(long) - previous)
+	}
+	for {
+		line, err := reader.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			if maxLineLen > 0 {
+				return tooLong()
+			}
+			if err := grow(line); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(long) > 0 {
+			if err := grow(line); err != nil {
+				return err
+			}
+			line = long
+		}
+		if err == nil || len(line) > 0 {
+			line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+			if maxLineLen > 0 && len(line) > maxLineLen {
+				return tooLong()
+			}
+			if fnErr := fn(line); fnErr != nil {
+				return fnErr
+			}
+		}
+		long = long[:0]
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package requestbody
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBufioBody(t *testing.T) {
+	t.Parallel()
+
+	t.Run("lines from gzip body", func(t *testing.T) {
+		t.Parallel()
+		lines := make(chan []string, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			reader := BufioBody(r, 0)
+			var read []string
+			for {
+				line, err := reader.ReadString('\n')
+				if line != "" {
+					read = append(read, strings.TrimSuffix(line, "\n"))
+				}
+				if err != nil {
+					break
+				}
+			}
+			lines <- read
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, []byte("first\nsecond\nthird")))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, []string{"first", "second", "third"}, <-lines)
+	})
+
+	t.Run("typed errors", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := BufioBody(r, 16).ReadString('\n')
+			errs <- err
+		}
+		ts := setupServer(t, handler, ContentLengthLimit(10), ReturnOnError())
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, []byte(strings.Repeat("long line ", 10))))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(<-errs, &tooLarge))
+	})
+}
+
+func TestEachLine(t *testing.T) {
+	t.Parallel()
+
+	type result struct {
+		lines []string
+		err   error
+	}
+	serve := func(t *testing.T, encoding string, body []byte, maxLineLen int, opts ...Option) (result, *http.Response) {
+		t.Helper()
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			var lines []string
+			var err error
+			// Send the result even when the error handler stops the handler.
+			defer func() { results <- result{lines, err} }()
+			err = EachLine(r, func(line []byte) error {
+				lines = append(lines, string(line))
+				return nil
+			}, maxLineLen)
+		}
+		ts := setupServer(t, handler, opts...)
+		response := postEncoded(t, ts, encoding, body)
+		return <-results, response
+	}
+
+	t.Run("gzip ndjson", func(t *testing.T) {
+		t.Parallel()
+		body := "{\"id\":1}\n{\"id\":2}\r\n\n{\"id\":3}"
+
+		result, response := serve(t, "gzip", gzipBytes(t, []byte(body)), 64)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertNoError(t, result.err)
+		assertEqual(t, []string{`{"id":1}`, `{"id":2}`, "", `{"id":3}`}, result.lines)
+	})
+
+	t.Run("trailing newline", func(t *testing.T) {
+		t.Parallel()
+
+		result, _ := serve(t, "", []byte("first\nsecond\n"), 64)
+
+		assertNoError(t, result.err)
+		assertEqual(t, []string{"first", "second"}, result.lines)
+	})
+
+	t.Run("overlong line", func(t *testing.T) {
+		t.Parallel()
+		body := "short\n" + strings.Repeat("x", 100) + "\nafter\n"
+
+		result, response := serve(t, "gzip", gzipBytes(t, []byte(body)), 32)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		assertEqual(t, []string{"short"}, result.lines)
+	})
+
+	t.Run("overlong line returning errors", func(t *testing.T) {
+		t.Parallel()
+		body := strings.Repeat("x", 33) + "\n"
+
+		result, _ := serve(t, "", []byte(body), 32, ReturnOnError())
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(result.err, &badRequest))
+		assertEqual(t, "Bad Request: line exceeds the maximum length of 32 bytes", badRequest.Error())
+	})
+
+	t.Run("unlimited line length", func(t *testing.T) {
+		t.Parallel()
+		long := strings.Repeat("x", 10000)
+
+		result, _ := serve(t, "gzip", gzipBytes(t, []byte("a\n"+long+"\nb")), 0)
+
+		assertNoError(t, result.err)
+		assertEqual(t, []string{"a", long, "b"}, result.lines)
+	})
+
+	t.Run("body limit", func(t *testing.T) {
+		t.Parallel()
+
+		result, _ := serve(t, "gzip", gzipBytes(t, []byte(strings.Repeat("line\n", 10))), 64, ContentLengthLimit(10), ReturnOnError())
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(result.err, &tooLarge))
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		t.Parallel()
+		stop := errors.New("stop")
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			errs <- EachLine(r, func(line []byte) error { return stop }, 0)
+		}
+		ts := setupServer(t, handler)
+
+		postEncoded(t, ts, "", []byte("first\nsecond"))
+
+		assertEqual(t, stop, <-errs)
+	})
+
+	t.Run("long line over total buffer cap", func(t *testing.T) {
+		t.Parallel()
+		long := strings.Repeat("x", 10000)
+
+		result, _ := serve(t, "", []byte("a\n"+long+"\nb"), 0, MaxTotalBufferBytes(8192), ReturnOnError())
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(result.err, &tooLarge))
+		assertEqual(t, []string{"a"}, result.lines)
+	})
+
+	t.Run("line buffers released", func(t *testing.T) {
+		t.Parallel()
+		buffered := make(chan int64, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_ = EachLine(r, func(line []byte) error { return nil }, 0)
+			body, _ := bodyFromRequest(r)
+			buffered <- body.bufferedBytes
+		}
+		ts := setupServer(t, handler, MaxTotalBufferBytes(1<<20))
+
+		postEncoded(t, ts, "", []byte("a\n"+strings.Repeat("x", 10000)+"\nb"))
+
+		assertEqual(t, int64(0), <-buffered)
+	})
+}
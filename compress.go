@@ -0,0 +1,247 @@
+package requestbody
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+// compressMagic starts every stream produced by compress(1), followed by a flags byte.
+var compressMagic = [2]byte{0x1f, 0x9d}
+
+const (
+	compressMaxBitsMask   = 0x1f
+	compressBlockMode     = 0x80
+	compressReservedFlags = 0x60
+	compressClearCode     = 256
+)
+
+var errInvalidCompressCode = errors.New("invalid compress code")
+
+// CompressEncodingReader decodes a body encoded using the obsolete "compress" content-coding, the .Z format
+// produced by the Unix compress(1) utility. It isn't supported by default, but can be registered for legacy
+// clients using SupportEncoding("compress", CompressEncodingReader), along with the "x-compress" alias.
+//
+// The format uses LSB-first variable-width LZW codes of up to 16 bits, which the compress/lzw package
+// doesn't support, as it only implements the variant used by GIF and PDF with codes of up to 12 bits.
+// The stream header is read when the reader is created, so an invalid header returns an error.
+func CompressEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	input, ok := r.(io.ByteReader)
+	if !ok {
+		input = bufio.NewReader(r)
+	}
+	var header [3]byte
+	for i := range header {
+		b, err := input.ReadByte()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		header[i] = b
+	}
+	if header[0] != compressMagic[0] || header[1] != compressMagic[1] {
+		return nil, errors.New("invalid compress header")
+	}
+	flags := header[2]
+	if flags&compressReservedFlags != 0 {
+		return nil, errors.New("unknown compress flags set")
+	}
+	maxBits := uint(flags & compressMaxBitsMask)
+	if maxBits < 9 || maxBits > 16 {
+		return nil, errors.New("compress code size out of range")
+	}
+	if maxBits == 9 {
+		// compress(1) has always treated 9 bits as 10.
+		maxBits = 10
+	}
+	z := &compressReader{
+		input:     input,
+		blockMode: flags&compressBlockMode != 0,
+		maxBits:   maxBits,
+		bits:      9,
+		mask:      1<<9 - 1,
+		end:       compressClearCode - 1,
+	}
+	if z.blockMode {
+		z.end = compressClearCode
+	}
+	return io.NopCloser(z), nil
+}
+
+// compressReader decodes the LZW codes following the header, one code at a time, following the decoder
+// in pigz. Codes are written in groups of as many bytes as the code size, and the rest of a group is
+// skipped when the code size changes.
+type compressReader struct {
+	input     io.ByteReader
+	blockMode bool
+	maxBits   uint
+	// bits is the current code size, and mask selects a code of that size.
+	bits uint
+	mask int
+	// end is the last code in the table.
+	end    int
+	prefix [1 << 16]uint16
+	suffix [1 << 16]byte
+	// prev is the previous code, and final is the first byte of its output.
+	prev, final int
+	// rem holds left unused bits from the last byte read.
+	rem  uint
+	left uint
+	// chunk is the number of bytes left in the current group of codes.
+	chunk   uint
+	started bool
+	// stack holds the output of the current code in reverse order, and pending the output not yet returned.
+	stack   []byte
+	pending []byte
+	err     error
+}
+
+func (z *compressReader) Read(p []byte) (int, error) {
+	for len(z.pending) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.decode()
+	}
+	n := copy(p, z.pending)
+	z.pending = z.pending[n:]
+	return n, nil
+}
+
+// next returns the next input byte, or -1 at the end of the input.
+func (z *compressReader) next() (int, error) {
+	b, err := z.input.ReadByte()
+	if err == io.EOF {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(b), nil
+}
+
+// skipGroup discards the rest of the current group of codes.
+func (z *compressReader) skipGroup() error {
+	z.left, z.rem = 0, 0
+	for ; z.chunk > 0; z.chunk-- {
+		b, err := z.next()
+		if err != nil {
+			return err
+		}
+		if b == -1 {
+			z.chunk = 0
+			return nil
+		}
+	}
+	return nil
+}
+
+// decode decodes the next code into pending, returning io.EOF at the end of the input.
+func (z *compressReader) decode() error {
+	if !z.started {
+		// The first code is a literal, and doesn't add a table entry.
+		z.started = true
+		b, err := z.next()
+		if err != nil {
+			return err
+		}
+		if b == -1 {
+			return io.EOF // An empty stream is valid.
+		}
+		z.prev, z.final = b, b
+		if b, err = z.next(); err != nil {
+			return err
+		}
+		if b == -1 {
+			return io.ErrUnexpectedEOF
+		}
+		if b&1 != 0 {
+			return errInvalidCompressCode
+		}
+		z.rem, z.left, z.chunk = uint(b)>>1, 7, z.bits-2
+		z.pending = append(z.pending[:0], byte(z.final))
+		return nil
+	}
+
+	for {
+		if z.end >= z.mask && z.bits < z.maxBits {
+			// The table is full for the current code size.
+			if err := z.skipGroup(); err != nil {
+				return err
+			}
+			z.bits++
+			z.mask = z.mask<<1 | 1
+		}
+
+		if z.chunk == 0 {
+			z.chunk = z.bits
+		}
+		code := z.rem
+		b, err := z.next()
+		if err != nil {
+			return err
+		}
+		if b == -1 {
+			return io.EOF
+		}
+		code += uint(b) << z.left
+		z.left += 8
+		z.chunk--
+		if z.bits > z.left {
+			if b, err = z.next(); err != nil {
+				return err
+			}
+			if b == -1 {
+				return io.ErrUnexpectedEOF
+			}
+			code += uint(b) << z.left
+			z.left += 8
+			z.chunk--
+		}
+		code &= uint(z.mask)
+		z.left -= z.bits
+		z.rem = uint(b) >> (8 - z.left)
+
+		if code == compressClearCode && z.blockMode {
+			if err := z.skipGroup(); err != nil {
+				return err
+			}
+			z.bits, z.mask, z.end = 9, 1<<9-1, compressClearCode-1
+			continue
+		}
+
+		current := int(code)
+		c := current
+		stack := z.stack[:0]
+		if c > z.end {
+			// The code being defined by this code, which repeats the previous output and its first byte.
+			if c != z.end+1 || z.prev > z.end {
+				return errInvalidCompressCode
+			}
+			stack = append(stack, byte(z.final))
+			c = z.prev
+		}
+		for c >= compressClearCode {
+			stack = append(stack, z.suffix[c])
+			c = int(z.prefix[c])
+		}
+		stack = append(stack, byte(c))
+		z.final = c
+
+		if z.end < z.mask {
+			z.end++
+			z.prefix[z.end] = uint16(z.prev)
+			z.suffix[z.end] = byte(z.final)
+		}
+		z.prev = current
+
+		z.pending = z.pending[:0]
+		for i := len(stack) - 1; i >= 0; i-- {
+			z.pending = append(z.pending, stack[i])
+		}
+		z.stack = stack
+		return nil
+	}
+}
@@ -0,0 +1,179 @@
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCompressEncodingReader(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		for _, maxBits := range []uint{10, 12, 16} {
+			for _, data := range [][]byte{
+				nil,
+				[]byte("a"),
+				[]byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"),
+				sourceData,
+				pseudoRandomBytes(70000),
+			} {
+				reader, err := CompressEncodingReader(bytes.NewReader(compressBytes(t, data, maxBits)))
+				assertNoError(t, err)
+
+				decoded, err := io.ReadAll(reader)
+
+				assertNoError(t, err)
+				assertEqual(t, true, bytes.Equal(data, decoded))
+			}
+		}
+	})
+
+	t.Run("registered", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncoding("compress", CompressEncodingReader))
+
+		response := postEncoded(t, ts, "compress", compressBytes(t, sourceData, 16))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("unsupported by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "compress", compressBytes(t, sourceData, 16))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncoding("compress", CompressEncodingReader))
+
+		response := postEncoded(t, ts, "compress", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("invalid code", func(t *testing.T) {
+		t.Parallel()
+		// A literal followed by a code which isn't in the table.
+		reader, err := CompressEncodingReader(bytes.NewReader([]byte{0x1f, 0x9d, 0x90, 'a', 0xfe, 0x03}))
+		assertNoError(t, err)
+
+		_, err = io.ReadAll(reader)
+
+		assertEqual(t, errInvalidCompressCode, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		t.Parallel()
+		encoded := compressBytes(t, sourceData, 16)
+		reader, err := CompressEncodingReader(bytes.NewReader(encoded[:len(encoded)/2]))
+		assertNoError(t, err)
+
+		decoded, _ := io.ReadAll(reader)
+
+		assertEqual(t, false, bytes.Equal(sourceData, decoded))
+	})
+}
+
+// pseudoRandomBytes returns pseudo-random bytes, which quickly fill the code table so the code size grows
+// to the maximum and the table is cleared.
+func pseudoRandomBytes(size int) []byte {
+	body := make([]byte, size)
+	state := uint32(size)
+	for i := range body {
+		state = state*1664525 + 1013904223
+		body[i] = byte(state >> 24)
+	}
+	return body
+}
+
+// compressBytes encodes the data in the .Z format as written by compress(1) in block mode, clearing
+// the code table once it's full.
+func compressBytes(t *testing.T, data []byte, maxBits uint) []byte {
+	t.Helper()
+
+	out := []byte{0x1f, 0x9d, 0x80 | byte(maxBits)}
+	bits := uint(9)
+	maxCode := 1<<bits - 1
+	freeEnt := compressClearCode + 1
+	table := map[[2]int]int{}
+	// Codes are buffered in groups of 8, which is as many bytes as the code size.
+	var group []byte
+	var acc uint
+	var accBits uint
+	codes := 0
+	flushGroup := func() {
+		if accBits > 0 {
+			group = append(group, byte(acc))
+		}
+		out = append(out, group...)
+		group, acc, accBits, codes = group[:0], 0, 0, 0
+	}
+	emit := func(code int, clear bool) {
+		acc |= uint(code) << accBits
+		accBits += bits
+		for accBits >= 8 {
+			group = append(group, byte(acc))
+			acc >>= 8
+			accBits -= 8
+		}
+		if codes++; codes == 8 {
+			flushGroup()
+		}
+		if freeEnt > maxCode || clear {
+			// The rest of the group is padded when the code size changes.
+			if codes > 0 {
+				if accBits > 0 {
+					group = append(group, byte(acc))
+					acc, accBits = 0, 0
+				}
+				group = append(group, make([]byte, int(bits)-len(group))...)
+				flushGroup()
+			}
+			if clear {
+				bits = 9
+			} else {
+				bits++
+			}
+			maxCode = 1<<bits - 1
+			if bits == maxBits {
+				maxCode = 1 << maxBits
+			}
+		}
+	}
+
+	if len(data) == 0 {
+		return out
+	}
+	ent := int(data[0])
+	for _, c := range data[1:] {
+		if code, ok := table[[2]int{ent, int(c)}]; ok {
+			ent = code
+			continue
+		}
+		emit(ent, false)
+		if freeEnt < 1<<maxBits {
+			table[[2]int{ent, int(c)}] = freeEnt
+			freeEnt++
+		} else {
+			clear(table)
+			freeEnt = compressClearCode + 1
+			emit(compressClearCode, true)
+		}
+		ent = int(c)
+	}
+	emit(ent, false)
+	flushGroup()
+	return out
+}
@@ -0,0 +1,95 @@
+package requestbody
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// TooManyConcurrentBodiesError is returned when the body needs decoding but the MaxConcurrentBodies
+// limit is reached, and no other body finished decoding within the wait set by MaxConcurrentBodiesWait.
+// The recommended status code for this error is 503 Service Unavailable.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9110.html#name-503-service-unavailable
+type TooManyConcurrentBodiesError struct {
+	Limit int
+}
+
+func (e *TooManyConcurrentBodiesError) Error() string {
+	return fmt.Sprintf("Service Unavailable: more than %d concurrent bodies", e.Limit)
+}
+func (e *TooManyConcurrentBodiesError) RecommendedStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+// MaxConcurrentBodies limits the number of request bodies being decoded at the same time across all
+// requests handled by the middleware, as decompression is CPU and memory intensive. A slot is taken when
+// a body with a Content-Encoding is first read, and released when the body is closed or the handler returns.
+// Bodies without a Content-Encoding don't take a slot.
+// If no slot is available, a TooManyConcurrentBodiesError will be returned.
+// This is disabled by default, or when set to zero or less.
+// This option only has an effect when passed to RequestBodyHandler.
+func MaxConcurrentBodies(n int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxConcurrentBodies = n
+		},
+	}
+}
+
+// MaxConcurrentBodiesWait sets how long to wait for a slot when the MaxConcurrentBodies limit is reached,
+// before returning a TooManyConcurrentBodiesError. The wait also ends if the request is canceled.
+// By default the error is returned immediately.
+func MaxConcurrentBodiesWait(d time.Duration) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxConcurrentBodiesWait = d
+		},
+	}
+}
+
+// bodySemaphore is shared by all requests handled by a RequestBodyHandler.
+type bodySemaphore struct {
+	slots chan struct{}
+}
+
+func newBodySemaphore(limit int) *bodySemaphore {
+	if limit <= 0 {
+		return nil
+	}
+	return &bodySemaphore{slots: make(chan struct{}, limit)}
+}
+
+func (s *bodySemaphore) acquire(ctx context.Context, wait time.Duration) RequestBodyError {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case s.slots <- struct{}{}:
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return &TooManyConcurrentBodiesError{Limit: cap(s.slots)}
+}
+
+// bodySlot is the slot held by a single request body.
+type bodySlot struct {
+	semaphore *bodySemaphore
+	held      atomic.Bool
+}
+
+// release frees the slot if it's held, and is safe to call more than once.
+func (s *bodySlot) release() {
+	if s.held.Swap(false) {
+		<-s.semaphore.slots
+	}
+}
@@ -0,0 +1,136 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaxConcurrentBodies(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	// newHandler returns a shared middleware where requests to /hold read the body then wait until released,
+	// and requests to /close also close the body before waiting.
+	newHandler := func(release chan struct{}, holding chan struct{}, opts ...Option) (http.Handler, chan error) {
+		errs := make(chan error, 10)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			switch r.URL.Path {
+			case "/close":
+				r.Body.Close()
+				fallthrough
+			case "/hold":
+				holding <- struct{}{}
+				<-release
+			}
+			errs <- err
+		}
+		return RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...), errs
+	}
+	newRequest := func(path, encoding string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		return req
+	}
+
+	t.Run("limit reached", func(t *testing.T) {
+		t.Parallel()
+		release, holding := make(chan struct{}), make(chan struct{})
+		handler, errs := newHandler(release, holding, MaxConcurrentBodies(1))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			handler.ServeHTTP(httptest.NewRecorder(), newRequest("/hold", "gzip", gzipBytes(t, sourceData)))
+		}()
+		<-holding
+
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		err := <-errs
+		var concurrentErr *TooManyConcurrentBodiesError
+		assertEqual(t, true, errors.As(err, &concurrentErr))
+		assertEqual(t, 1, concurrentErr.Limit)
+		assertEqual(t, http.StatusServiceUnavailable, concurrentErr.RecommendedStatusCode())
+
+		// Bodies without an encoding don't need a slot.
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "", sourceData))
+		assertNoError(t, <-errs)
+
+		close(release)
+		<-done
+		assertNoError(t, <-errs)
+
+		// The slot is released once the handler returns.
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		assertNoError(t, <-errs)
+	})
+
+	t.Run("released on close", func(t *testing.T) {
+		t.Parallel()
+		release, holding := make(chan struct{}), make(chan struct{})
+		handler, errs := newHandler(release, holding, MaxConcurrentBodies(1))
+		go handler.ServeHTTP(httptest.NewRecorder(), newRequest("/close", "gzip", gzipBytes(t, sourceData)))
+		<-holding
+		defer close(release)
+
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		assertNoError(t, <-errs)
+	})
+
+	t.Run("wait for slot", func(t *testing.T) {
+		t.Parallel()
+		release, holding := make(chan struct{}), make(chan struct{})
+		handler, errs := newHandler(release, holding, MaxConcurrentBodies(1), MaxConcurrentBodiesWait(5*time.Second))
+		go handler.ServeHTTP(httptest.NewRecorder(), newRequest("/hold", "gzip", gzipBytes(t, sourceData)))
+		<-holding
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		assertNoError(t, <-errs)
+		assertNoError(t, <-errs)
+	})
+
+	t.Run("status code", func(t *testing.T) {
+		t.Parallel()
+		release, holding := make(chan struct{}), make(chan struct{})
+		errs := make(chan error, 2)
+		handler := RequestBodyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			if r.URL.Path == "/hold" {
+				holding <- struct{}{}
+				<-release
+			}
+			errs <- err
+		}), MaxConcurrentBodies(1))
+		go handler.ServeHTTP(httptest.NewRecorder(), newRequest("/hold", "gzip", gzipBytes(t, sourceData)))
+		<-holding
+		defer close(release)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		assertEqual(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+
+	t.Run("unlimited by default", func(t *testing.T) {
+		t.Parallel()
+		release, holding := make(chan struct{}), make(chan struct{})
+		handler, errs := newHandler(release, holding)
+		go handler.ServeHTTP(httptest.NewRecorder(), newRequest("/hold", "gzip", gzipBytes(t, sourceData)))
+		<-holding
+
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("/", "gzip", gzipBytes(t, sourceData)))
+		assertNoError(t, <-errs)
+		close(release)
+		assertNoError(t, <-errs)
+	})
+}
@@ -0,0 +1,188 @@
+package requestbody
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config is a declarative alternative to passing options to RequestBodyHandler, which can be built, compared
+// and passed around as a value. The zero value uses the same defaults as RequestBodyHandler without options.
+//
+// Settings without a field can be applied using Options, which are applied after the fields.
+type Config struct {
+	// MaxContentLength is the content length limit, as set by ContentLengthLimit. Zero uses the default
+	// limit of 10MB, and -1 disables the limit.
+	MaxContentLength int64
+	// RequireContentLength requires requests to declare their content length, as set by RequireContentLength.
+	RequireContentLength bool
+	// SupportedEncodings adds or replaces encodings by name, as set by SupportEncoding.
+	SupportedEncodings map[string]EncodingReader
+	// DisabledEncodings removes encodings by name, including those supported by default, as set by
+	// DisableEncoding. They're removed after SupportedEncodings are added.
+	DisabledEncodings []string
+	// MaxEncodingLayers limits the number of stacked content-codings, as set by MaxEncodingLayers. Zero uses
+	// the default limit of 3, and -1 disables the limit.
+	MaxEncodingLayers int
+	// MaxEncodingTokens limits the number of Content-Encoding tokens, as set by MaxEncodingTokens.
+	// Zero disables the limit.
+	MaxEncodingTokens int
+	// DecodeByteBudget limits the bytes fed into decoders, as set by DecodeByteBudget. Zero disables the limit.
+	DecodeByteBudget int64
+	// DecompressedSizeLimit limits the decoded body separately from its raw size, as set by
+	// DecompressedSizeLimit. Zero disables the limit.
+	DecompressedSizeLimit int64
+	// MaxTotalBufferBytes limits the buffers held for a request, as set by MaxTotalBufferBytes.
+	// Zero disables the limit.
+	MaxTotalBufferBytes int64
+	// MaxFinalRatio limits the ratio of decoded to raw bytes, as set by MaxFinalRatio. Zero disables the check.
+	MaxFinalRatio float64
+	// MaxCompressionRatio limits the ratio of decoded to raw bytes while reading, as set by MaxCompressionRatio.
+	// Zero disables the check.
+	MaxCompressionRatio float64
+	// StrictEncodingParsing rejects content-codings with parameters, as set by StrictEncodingParsing.
+	StrictEncodingParsing bool
+	// StrictAdvertisedEncodings rejects codings which aren't advertised, as set by StrictAdvertisedEncodings.
+	StrictAdvertisedEncodings bool
+	// DisableAntiSmuggling disables the AntiSmuggling checks, which are enabled by default.
+	DisableAntiSmuggling bool
+	// InitTimeout bounds the time spent constructing decoders, as set by InitTimeout. Zero disables the timeout.
+	InitTimeout time.Duration
+	// ReadTimeout bounds the time spent reading the body, as set by ReadTimeout. Zero disables the timeout.
+	ReadTimeout time.Duration
+	// ErrorHandler handles errors, as set by HandleRequestBodyError. When nil, the default error handler is
+	// used, unless ReturnOnError is set.
+	ErrorHandler RequestBodyErrorHandler
+	// ReturnOnError returns errors from reads of the body, as set by ReturnOnError, and can't be combined
+	// with an ErrorHandler.
+	ReturnOnError bool
+	// Options are applied after the fields, such as for settings without a field.
+	Options []Option
+}
+
+// Validate reports any settings which are invalid, such as negative limits other than -1.
+func (c Config) Validate() error {
+	var errs []error
+	if c.MaxContentLength < -1 {
+		errs = append(errs, fmt.Errorf("MaxContentLength must be -1 or more, got %d", c.MaxContentLength))
+	}
+	if c.MaxEncodingLayers < -1 {
+		errs = append(errs, fmt.Errorf("MaxEncodingLayers must be -1 or more, got %d", c.MaxEncodingLayers))
+	}
+	for name, value := range map[string]int64{
+		"MaxEncodingTokens":     int64(c.MaxEncodingTokens),
+		"DecodeByteBudget":      c.DecodeByteBudget,
+		"DecompressedSizeLimit": c.DecompressedSizeLimit,
+		"MaxTotalBufferBytes":   c.MaxTotalBufferBytes,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %d", name, value))
+		}
+	}
+	for name, value := range map[string]time.Duration{
+		"InitTimeout": c.InitTimeout,
+		"ReadTimeout": c.ReadTimeout,
+	} {
+		if value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %v", name, value))
+		}
+	}
+	if c.MaxFinalRatio < 0 {
+		errs = append(errs, fmt.Errorf("MaxFinalRatio must not be negative, got %v", c.MaxFinalRatio))
+	}
+	if c.MaxCompressionRatio < 0 {
+		errs = append(errs, fmt.Errorf("MaxCompressionRatio must not be negative, got %v", c.MaxCompressionRatio))
+	}
+	for name, reader := range c.SupportedEncodings {
+		if strings.TrimSpace(name) == "" || reader == nil {
+			errs = append(errs, fmt.Errorf("SupportedEncodings must have a name and reader, got %q", name))
+		}
+	}
+	if c.ErrorHandler != nil && c.ReturnOnError {
+		errs = append(errs, errors.New("ErrorHandler can't be combined with ReturnOnError"))
+	}
+	// Sort so the error is stable, as the limits are checked in map order.
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("requestbody: invalid config: %w", err)
+	}
+	return nil
+}
+
+// options returns the options equivalent to the config.
+func (c Config) options() Options {
+	var opts Options
+	if c.MaxContentLength != 0 {
+		opts = append(opts, ContentLengthLimit(c.MaxContentLength))
+	}
+	if c.RequireContentLength {
+		opts = append(opts, RequireContentLength(true))
+	}
+	names := make([]string, 0, len(c.SupportedEncodings))
+	for name := range c.SupportedEncodings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		opts = append(opts, SupportEncoding(name, c.SupportedEncodings[name]))
+	}
+	for _, name := range c.DisabledEncodings {
+		opts = append(opts, DisableEncoding(name))
+	}
+	if c.MaxEncodingLayers != 0 {
+		opts = append(opts, MaxEncodingLayers(c.MaxEncodingLayers))
+	}
+	if c.MaxEncodingTokens != 0 {
+		opts = append(opts, MaxEncodingTokens(c.MaxEncodingTokens))
+	}
+	if c.DecodeByteBudget != 0 {
+		opts = append(opts, DecodeByteBudget(c.DecodeByteBudget))
+	}
+	if c.DecompressedSizeLimit != 0 {
+		opts = append(opts, DecompressedSizeLimit(c.DecompressedSizeLimit))
+	}
+	if c.MaxTotalBufferBytes != 0 {
+		opts = append(opts, MaxTotalBufferBytes(c.MaxTotalBufferBytes))
+	}
+	if c.MaxFinalRatio != 0 {
+		opts = append(opts, MaxFinalRatio(c.MaxFinalRatio))
+	}
+	if c.MaxCompressionRatio != 0 {
+		opts = append(opts, MaxCompressionRatio(c.MaxCompressionRatio))
+	}
+	if c.StrictEncodingParsing {
+		opts = append(opts, StrictEncodingParsing(true))
+	}
+	if c.StrictAdvertisedEncodings {
+		opts = append(opts, StrictAdvertisedEncodings(true))
+	}
+	if c.DisableAntiSmuggling {
+		opts = append(opts, AntiSmuggling(false))
+	}
+	if c.InitTimeout != 0 {
+		opts = append(opts, InitTimeout(c.InitTimeout))
+	}
+	if c.ReadTimeout != 0 {
+		opts = append(opts, ReadTimeout(c.ReadTimeout))
+	}
+	if c.ErrorHandler != nil {
+		opts = append(opts, HandleRequestBodyError(c.ErrorHandler))
+	}
+	if c.ReturnOnError {
+		opts = append(opts, ReturnOnError())
+	}
+	return append(opts, c.Options...)
+}
+
+// RequestBodyHandlerWithConfig wraps the handler as RequestBodyHandler does, configured using the config
+// rather than options. It panics with the error from Config.Validate if the config is invalid, so call
+// Validate first when the config comes from user input.
+func RequestBodyHandlerWithConfig(h http.Handler, cfg Config) http.Handler {
+	if err := cfg.Validate(); err != nil {
+		panic(err)
+	}
+	return RequestBodyHandler(h, cfg.options()...)
+}
@@ -0,0 +1,156 @@
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestBodyHandlerWithConfig(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, cfg Config, encoding string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandlerWithConfig(http.HandlerFunc(echoHandler()), cfg).ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("zero value uses defaults", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, Config{}, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("content length limit", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, Config{MaxContentLength: 10}, "", sourceData)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		t.Parallel()
+		body := make([]byte, 11*1024*1024)
+
+		response := serve(t, Config{MaxContentLength: -1}, "", body)
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, len(body), response.Body.Len())
+	})
+
+	t.Run("encodings", func(t *testing.T) {
+		t.Parallel()
+		cfg := Config{
+			SupportedEncodings: map[string]EncodingReader{"custom": identityEncodingReader},
+			DisabledEncodings:  []string{"gzip"},
+		}
+
+		custom := serve(t, cfg, "custom", sourceData)
+		disabled := serve(t, cfg, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, custom.Code)
+		assertEqual(t, http.StatusUnsupportedMediaType, disabled.Code)
+	})
+
+	t.Run("error handler", func(t *testing.T) {
+		t.Parallel()
+		cfg := Config{
+			MaxContentLength: 10,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+				w.WriteHeader(http.StatusTeapot)
+			},
+		}
+
+		response := serve(t, cfg, "", sourceData)
+
+		assertEqual(t, http.StatusTeapot, response.Code)
+	})
+
+	t.Run("options applied after fields", func(t *testing.T) {
+		t.Parallel()
+		cfg := Config{MaxContentLength: 10, Options: []Option{ContentLengthLimit(100)}}
+
+		response := serve(t, cfg, "", sourceData)
+
+		assertEqual(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("matches options", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		cfg := Config{MaxEncodingLayers: 1, ReturnOnError: true}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, gzipBytes(t, sourceData))))
+		req.Header.Set("Content-Encoding", "gzip, gzip")
+
+		RequestBodyHandlerWithConfig(http.HandlerFunc(handler), cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+		bodyErr, ok := AsRequestBodyError(<-errs)
+		assertEqual(t, true, ok)
+		assertEqual(t, http.StatusBadRequest, bodyErr.RecommendedStatusCode())
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("valid", func(t *testing.T) {
+		t.Parallel()
+
+		assertNoError(t, Config{}.Validate())
+		assertNoError(t, Config{MaxContentLength: -1, MaxEncodingLayers: -1}.Validate())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+
+		err := Config{
+			MaxContentLength:    -2,
+			MaxTotalBufferBytes: -5,
+			InitTimeout:         -time.Second,
+			ReadTimeout:         -time.Millisecond,
+			MaxCompressionRatio: -1,
+			ReturnOnError:       true,
+			ErrorHandler:        StatusOnlyRequestBodyErrorHandler,
+		}.Validate()
+
+		assertEqual(t, true, err != nil)
+		for _, expected := range []string{
+			"MaxContentLength must be -1 or more, got -2",
+			"MaxTotalBufferBytes must not be negative, got -5",
+			"InitTimeout must not be negative, got -1s",
+			"ReadTimeout must not be negative, got -1ms",
+			"MaxCompressionRatio must not be negative, got -1",
+			"ErrorHandler can't be combined with ReturnOnError",
+		} {
+			assertEqual(t, true, strings.Contains(err.Error(), expected))
+		}
+	})
+
+	t.Run("handler panics", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			err, _ := recover().(error)
+			assertEqual(t, true, err != nil && strings.Contains(err.Error(), "DecodeByteBudget"))
+		}()
+
+		RequestBodyHandlerWithConfig(http.HandlerFunc(echoHandler()), Config{DecodeByteBudget: -1})
+	})
+}
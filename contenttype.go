@@ -0,0 +1,88 @@
+package requestbody
+
+import (
+	"slices"
+	"strings"
+)
+
+type exactContentType struct {
+	value           string
+	allowParameters bool
+}
+
+// ExactContentType requires the Content-Type header to be exactly the value, such as
+// "application/octet-stream" for strict binary endpoints, otherwise a RequestUnsupportedMediaTypeError
+// will be returned. The header is compared after trimming surrounding whitespace.
+// If allowParameters is true, any parameters such as "charset" are removed from the header before
+// comparing, otherwise a header with parameters is rejected unless the value includes them too.
+func ExactContentType(value string, allowParameters bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.exactContentType = &exactContentType{
+				value:           value,
+				allowParameters: allowParameters,
+			}
+		},
+	}
+}
+
+// AllowContentTypes restricts the media types accepted in the Content-Type header, otherwise a
+// RequestUnsupportedMediaTypeError will be returned, including when the header is missing.
+// Parameters such as "charset" are ignored, matching is case-insensitive, and wildcard subtypes
+// such as "application/*" match any subtype. Calling it with no types, the default, accepts any media type.
+// If set multiple times, the last allow-list replaces the earlier ones.
+func AllowContentTypes(types ...string) Option {
+	allowed := make([]string, len(types))
+	for i, contentType := range types {
+		allowed[i] = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return optionFunc{
+		f: func(opts *options) {
+			opts.allowedContentTypes = allowed
+		},
+	}
+}
+
+// checkContentType validates the Content-Type header against the configured options.
+func (r *lazyReader) checkContentType() RequestBodyError {
+	if exact := r.options.exactContentType; exact != nil {
+		contentType := strings.TrimSpace(r.contentType)
+		if exact.allowParameters {
+			contentType, _, _ = strings.Cut(contentType, ";")
+			contentType = strings.TrimSpace(contentType)
+		}
+		if contentType != exact.value {
+			return &RequestUnsupportedMediaTypeError{
+				ContentType: r.contentType,
+				Header:      "Content-Type",
+				Value:       r.contentType,
+			}
+		}
+	}
+	if allowed := r.options.allowedContentTypes; len(allowed) > 0 {
+		if mediaType := r.parsedHeaders().mediaType; !slices.ContainsFunc(allowed, func(pattern string) bool {
+			return matchMediaType(pattern, mediaType)
+		}) {
+			return &RequestUnsupportedMediaTypeError{
+				ContentType: r.contentType,
+				Header:      "Content-Type",
+				Value:       r.contentType,
+			}
+		}
+	}
+	return nil
+}
+
+// matchMediaType reports whether the lowercase media type matches the pattern, which may use a
+// wildcard subtype such as "application/*", or "*/*" to match any media type.
+func matchMediaType(pattern, mediaType string) bool {
+	if mediaType == "" {
+		return false
+	}
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	patternType, subtype, _ := strings.Cut(pattern, "/")
+	mediaTypeType, _, _ := strings.Cut(mediaType, "/")
+	return subtype == "*" && patternType == mediaTypeType
+}
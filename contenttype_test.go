@@ -0,0 +1,94 @@
+package requestbody
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+func TestExactContentType(t *testing.T) {
+	t.Parallel()
+
+	post := func(t *testing.T, contentType string, opts ...Option) *http.Response {
+		t.Helper()
+		ts := setupServer(t, echoHandler(), opts...)
+		response, err := ts.Client().Post(ts.URL, contentType, bytes.NewReader([]byte("data")))
+		assertNoError(t, err)
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	t.Run("exact match", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/octet-stream", ExactContentType("application/octet-stream", false))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "data", readString(t, response))
+	})
+
+	t.Run("surrounding whitespace", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, " application/octet-stream ", ExactContentType("application/octet-stream", false))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("different type", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/json", ExactContentType("application/octet-stream", false))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("parameters rejected", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/octet-stream; charset=utf-8", ExactContentType("application/octet-stream", false))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("parameters allowed", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/octet-stream; charset=utf-8", ExactContentType("application/octet-stream", true))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestAllowContentTypes(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name        string
+		contentType string
+		opts        Options
+		expected    int
+	}{
+		{"any by default", "image/png", nil, http.StatusOK},
+		{"allowed", "application/json", Options{AllowContentTypes("application/json", "text/plain")}, http.StatusOK},
+		{"parameters ignored", "text/plain; charset=utf-8", Options{AllowContentTypes("application/json", "text/plain")}, http.StatusOK},
+		{"case-insensitive", "Application/JSON", Options{AllowContentTypes("application/json")}, http.StatusOK},
+		{"case-insensitive allow-list", "application/json", Options{AllowContentTypes("Application/Json")}, http.StatusOK},
+		{"wildcard subtype", "application/vnd.api+json", Options{AllowContentTypes("application/*")}, http.StatusOK},
+		{"wildcard subtype mismatch", "text/plain", Options{AllowContentTypes("application/*")}, http.StatusUnsupportedMediaType},
+		{"not allowed", "application/xml", Options{AllowContentTypes("application/json")}, http.StatusUnsupportedMediaType},
+		{"missing", "", Options{AllowContentTypes("application/json")}, http.StatusUnsupportedMediaType},
+		{"per-request override", "application/xml", Options{AllowContentTypes("application/json"), AllowContentTypes()}, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(test.opts...))
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader([]byte("data")))
+			assertNoError(t, err)
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			response, err := ts.Client().Do(req)
+			assertNoError(t, err)
+			defer response.Body.Close()
+
+			assertEqual(t, test.expected, response.StatusCode)
+		})
+	}
+}
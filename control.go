@@ -0,0 +1,49 @@
+package requestbody
+
+import (
+	"fmt"
+	"io"
+)
+
+// RejectControlCharacters will return a BadRequestError when the decoded body contains a control
+// character, for endpoints accepting single-line tokens or other header-like values as the body.
+// Tab, line feed and carriage return are allowed, while other C0 controls, DEL, and C1 controls
+// encoded as UTF-8 are rejected. This is disabled by default.
+func RejectControlCharacters(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rejectControlCharacters = enable
+		},
+	}
+}
+
+// controlCharReader returns an error at the first control character in the decoded body.
+type controlCharReader struct {
+	io.ReadCloser
+	offset int64
+	// lead records that the last byte read was 0xC2, the UTF-8 lead byte of the C1 controls,
+	// which may be split from its continuation byte across reads.
+	lead bool
+}
+
+func (c *controlCharReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	for i, b := range p[:n] {
+		if isControlByte(b) || (c.lead && b >= 0x80 && b <= 0x9f) {
+			return i, &BadRequestError{
+				Err: fmt.Errorf("control character in body at offset %d", c.offset+int64(i)),
+			}
+		}
+		c.lead = b == 0xc2
+	}
+	c.offset += int64(n)
+	return n, err
+}
+
+func isControlByte(b byte) bool {
+	switch b {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return b < 0x20 || b == 0x7f
+}
@@ -0,0 +1,63 @@
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRejectControlCharacters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("plain token", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), RejectControlCharacters(true))
+
+		response := postEncoded(t, ts, "", []byte("token-abc123\tcafé\r\n"))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "token-abc123\tcafé\r\n", readString(t, response))
+	})
+
+	t.Run("embedded NUL", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), RejectControlCharacters(true))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, []byte("token\x00abc")))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "", []byte("token\x00abc"))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("C1 control split across reads", func(t *testing.T) {
+		t.Parallel()
+		reader := &controlCharReader{
+			ReadCloser: io.NopCloser(iotest.OneByteReader(bytes.NewReader([]byte("ab\u0085cd")))),
+		}
+
+		data, err := io.ReadAll(reader)
+
+		assertEqual(t, "ab\xc2", string(data))
+		assertEqual(t, "Bad Request: control character in body at offset 3", err.Error())
+	})
+
+	t.Run("DEL", func(t *testing.T) {
+		t.Parallel()
+		reader := &controlCharReader{ReadCloser: io.NopCloser(bytes.NewReader([]byte("ab\x7fcd")))}
+
+		data, err := io.ReadAll(reader)
+
+		assertEqual(t, "ab", string(data))
+		assertEqual(t, "Bad Request: control character in body at offset 2", err.Error())
+	})
+}
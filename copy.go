@@ -0,0 +1,60 @@
+package requestbody
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// copyBufferSize matches the buffer size used by io.Copy.
+const copyBufferSize = 32 * 1024
+
+// WriteError is returned when copying the body stops because writing to the destination failed,
+// rather than because of the body itself, so it isn't a RequestBodyError.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("failed to write request body: %v", e.Err)
+}
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// CopyBodyTo copies the decoded request body to dst, such as when proxying the body, returning the number
+// of bytes written. Reading stops as soon as a write fails, returning the write error wrapped in a WriteError.
+// Errors reading the body are handled in the same way as when reading from the body directly.
+//
+// The body is copied from r.Body, so a body replaced by MakeReplayable is copied from its buffer.
+func CopyBodyTo(dst io.Writer, r *http.Request) (int64, error) {
+	return copyBody(dst, r.Body)
+}
+
+// WriteTo implements io.WriterTo so io.Copy stops reading the body as soon as a write fails.
+func (r *lazyReader) WriteTo(w io.Writer) (int64, error) {
+	return copyBody(w, r)
+}
+
+func copyBody(dst io.Writer, src io.Reader) (written int64, err error) {
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			m, writeErr := dst.Write(buf[:n])
+			written += int64(m)
+			if writeErr == nil && m < n {
+				writeErr = io.ErrShortWrite
+			}
+			if writeErr != nil {
+				return written, &WriteError{Err: writeErr}
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
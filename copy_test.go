@@ -0,0 +1,138 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// failingWriter accepts a number of bytes, then fails every write.
+type failingWriter struct {
+	remaining int
+}
+
+var errWriteFailed = errors.New("destination closed")
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > f.remaining {
+		n := f.remaining
+		f.remaining = 0
+		return n, errWriteFailed
+	}
+	f.remaining -= len(p)
+	return len(p), nil
+}
+
+func TestCopyBodyTo(t *testing.T) {
+	t.Parallel()
+
+	sourceData := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog\n"), 100000)
+
+	type result struct {
+		written int64
+		err     error
+		stats   BodyStats
+	}
+	copyHandler := func(dst func() io.Writer, results chan<- result) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			written, err := CopyBodyTo(dst(), r)
+			stats, _ := RequestBodyStats(r)
+			results <- result{written, err, stats}
+		}
+	}
+
+	t.Run("copies decoded body", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		results := make(chan result, 1)
+		ts := setupServer(t, copyHandler(func() io.Writer { return &buf }, results))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		copied := <-results
+		assertNoError(t, copied.err)
+		assertEqual(t, int64(len(sourceData)), copied.written)
+		assertEqual(t, true, bytes.Equal(sourceData, buf.Bytes()))
+	})
+
+	t.Run("stops reading on write error", func(t *testing.T) {
+		t.Parallel()
+		results := make(chan result, 1)
+		ts := setupServer(t, copyHandler(func() io.Writer { return &failingWriter{remaining: 100} }, results))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		copied := <-results
+		assertEqual(t, int64(100), copied.written)
+		assertEqual(t, 1, copied.stats.ReadCalls)
+		var writeErr *WriteError
+		assertEqual(t, true, errors.As(copied.err, &writeErr))
+		assertEqual(t, true, errors.Is(copied.err, errWriteFailed))
+		var bodyErr RequestBodyError
+		assertEqual(t, false, errors.As(copied.err, &bodyErr))
+	})
+
+	t.Run("io.Copy uses WriteTo", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.Copy(&failingWriter{remaining: 100}, r.Body)
+			errs <- err
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, true, errors.Is(<-errs, errWriteFailed))
+	})
+
+	t.Run("body error", func(t *testing.T) {
+		t.Parallel()
+		results := make(chan result, 1)
+		ts := setupServer(t, copyHandler(func() io.Writer { return io.Discard }, results), ContentLengthLimit(100), ReturnOnError())
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As((<-results).err, &tooLarge))
+	})
+
+	t.Run("after MakeReplayable", func(t *testing.T) {
+		t.Parallel()
+		var buf bytes.Buffer
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			assertNoError(t, MakeReplayable(r, int64(len(sourceData))))
+			copyHandler(func() io.Writer { return &buf }, results)(w, r)
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		copied := <-results
+		assertNoError(t, copied.err)
+		assertEqual(t, int64(len(sourceData)), copied.written)
+		assertEqual(t, true, bytes.Equal(sourceData, buf.Bytes()))
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		assertNoError(t, err)
+		var buf bytes.Buffer
+
+		written, err := CopyBodyTo(&buf, req)
+
+		assertNoError(t, err)
+		assertEqual(t, int64(4), written)
+		assertEqual(t, "data", buf.String())
+	})
+}
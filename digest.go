@@ -0,0 +1,63 @@
+package requestbody
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// rawDigestAlgorithms are the hash algorithms supported by ComputeRawDigest, using the names from
+// the HTTP Hash Algorithms for HTTP Fields registry.
+var rawDigestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// ComputeRawDigest hashes the raw request body as it's read, before any content-codings are decoded,
+// for integrity schemes which cover the bytes as sent rather than the decoded content. The digest can
+// be retrieved using RawBodyDigest once the body has been read.
+// The supported algorithms are "sha-256" and "sha-512", matched case-insensitively, and
+// ComputeRawDigest panics if the algorithm isn't supported.
+func ComputeRawDigest(algo string) Option {
+	newHash, ok := rawDigestAlgorithms[strings.ToLower(algo)]
+	if !ok {
+		panic(fmt.Sprintf("requestbody: unsupported digest algorithm %q", algo))
+	}
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rawDigest = newHash
+		},
+	}
+}
+
+// RawBodyDigest returns the digest of the raw request body read so far, using the algorithm set by
+// ComputeRawDigest. The digest only covers the whole body once it's been read to the end, and it
+// shouldn't be called concurrently with reads of the body. The second return value is false if the
+// request wasn't wrapped by the RequestBodyHandler middleware or ComputeRawDigest isn't enabled.
+func RawBodyDigest(r *http.Request) ([]byte, bool) {
+	body, ok := bodyFromRequest(r)
+	if !ok || body.options.rawDigest == nil {
+		return nil, false
+	}
+	if body.rawHash == nil {
+		// The body hasn't been read, or was empty.
+		return body.options.rawDigest().Sum(nil), true
+	}
+	return body.rawHash.Sum(nil), true
+}
+
+// hashReader writes the bytes read from the wrapped reader to a hash.
+type hashReader struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	return n, err
+}
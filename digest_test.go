@@ -0,0 +1,90 @@
+package requestbody
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestComputeRawDigest(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	digestHandler := func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			return
+		}
+		digest, ok := RawBodyDigest(r)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(hex.EncodeToString(digest)))
+	}
+
+	t.Run("encoded body", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, digestHandler, ComputeRawDigest("sha-256"))
+		encoded := gzipBytes(t, sourceData)
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		expected := sha256.Sum256(encoded)
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, hex.EncodeToString(expected[:]), readString(t, response))
+	})
+
+	t.Run("unencoded body", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, digestHandler, ComputeRawDigest("SHA-512"))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		expected := sha512.Sum512(sourceData)
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, hex.EncodeToString(expected[:]), readString(t, response))
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, digestHandler, ComputeRawDigest("sha-256"))
+
+		response := postEncoded(t, ts, "", nil)
+
+		expected := sha256.Sum256(nil)
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, hex.EncodeToString(expected[:]), readString(t, response))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, digestHandler)
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusNotFound, response.StatusCode)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+
+		_, ok := RawBodyDigest(req)
+
+		assertEqual(t, false, ok)
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			assertEqual(t, true, recover() != nil)
+		}()
+
+		ComputeRawDigest("md5")
+	})
+}
@@ -0,0 +1,88 @@
+package requestbody
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// RequestTooManyFormFieldsError is returned by ReadForm when an urlencoded form contains more
+// fields than allowed by the MaxFormFields option.
+// The recommended status code for this error is 413 Request Entity Too Large.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9110.html#name-413-content-too-large
+type RequestTooManyFormFieldsError struct {
+	Limit int
+}
+
+func (e *RequestTooManyFormFieldsError) Error() string {
+	return fmt.Sprintf("Too Many Form Fields: limit %d", e.Limit)
+}
+func (e *RequestTooManyFormFieldsError) RecommendedStatusCode() int {
+	return http.StatusRequestEntityTooLarge
+}
+
+// MaxFormFields limits the number of fields which ReadForm will parse from an urlencoded form,
+// to avoid building huge maps from large forms.
+// This is disabled by default, or when set to zero or less.
+func MaxFormFields(n int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxFormFields = n
+		},
+	}
+}
+
+// ReadForm parses an application/x-www-form-urlencoded body, such as from a POST request, streaming the
+// decoded body one field at a time rather than buffering the whole form. The Content-Type header isn't
+// checked, and the request's Form and PostForm fields aren't modified.
+//
+// Once the form contains more fields than allowed by the MaxFormFields option, a RequestTooManyFormFieldsError
+// is returned without reading the rest of the body. An invalid escape returns a BadRequestError.
+// The parsed keys and values count towards the MaxTotalBufferBytes limit for the request.
+// Errors are handled in the same way as when reading from the body directly.
+func ReadForm(r *http.Request) (url.Values, error) {
+	maxFields := 0
+	handleBodyError := func(err error) error { return err }
+	body, ok := bodyFromRequest(r)
+	if ok {
+		maxFields = body.options.maxFormFields
+		handleBodyError = body.handleError
+	}
+
+	values := make(url.Values)
+	fields := 0
+	reader := bufio.NewReader(r.Body)
+	for {
+		pair, err := reader.ReadBytes('&')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if pair = bytes.TrimSuffix(pair, []byte("&")); len(pair) > 0 {
+			fields++
+			if maxFields > 0 && fields > maxFields {
+				return nil, handleBodyError(&RequestTooManyFormFieldsError{
+					Limit: maxFields,
+				})
+			}
+			key, value, _ := bytes.Cut(pair, []byte("="))
+			unescapedKey, keyErr := url.QueryUnescape(string(key))
+			unescapedValue, valueErr := url.QueryUnescape(string(value))
+			if keyErr != nil || valueErr != nil {
+				return nil, handleBodyError(&BadRequestError{
+					Err: fmt.Errorf("invalid form field %q", pair),
+				})
+			}
+			if err := body.chargeBuffer(int64(len(unescapedKey) + len(unescapedValue))); err != nil {
+				return nil, handleBodyError(err)
+			}
+			values.Add(unescapedKey, unescapedValue)
+		}
+		if err == io.EOF {
+			return values, nil
+		}
+	}
+}
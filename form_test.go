@@ -0,0 +1,98 @@
+package requestbody
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestReadForm(t *testing.T) {
+	t.Parallel()
+
+	formHandler := func(opts ...Option) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, opts...)
+			form, err := ReadForm(r)
+			if err != nil {
+				var fieldsErr *RequestTooManyFormFieldsError
+				if errors.As(err, &fieldsErr) {
+					http.Error(w, err.Error(), fieldsErr.RecommendedStatusCode())
+					return
+				}
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(form.Encode()))
+		}
+	}
+
+	t.Run("parses fields", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler())
+
+		response := postEncoded(t, ts, "", []byte("b=2&a=1&&a=hello+world%21&empty="))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "a=1&a=hello+world%21&b=2&empty=", readString(t, response))
+	})
+
+	t.Run("within field limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler(), MaxFormFields(3))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, []byte("a=1&b=2&c=3")))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "a=1&b=2&c=3", readString(t, response))
+	})
+
+	t.Run("exceeds field limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler(), MaxFormFields(3))
+
+		response := postEncoded(t, ts, "", []byte(strings.Repeat("a=1&", 1000)))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("returns error when configured", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler(ReturnOnError()), MaxFormFields(1))
+
+		response := postEncoded(t, ts, "", []byte("a=1&b=2"))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+		assertEqual(t, "Too Many Form Fields: limit 1\n", readString(t, response))
+	})
+
+	t.Run("invalid escape", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler())
+
+		response := postEncoded(t, ts, "", []byte("a=%zz"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader("a=1&b=2"))
+		assertNoError(t, err)
+
+		form, err := ReadForm(req)
+
+		assertNoError(t, err)
+		assertEqual(t, url.Values{"a": {"1"}, "b": {"2"}}, form)
+	})
+
+	t.Run("over total buffer cap", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, formHandler(), MaxTotalBufferBytes(10))
+
+		response := postEncoded(t, ts, "", []byte("a=12345&b=67890"))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
@@ -1,3 +1,8 @@
 module github.com/danielrbradley/requestbody
 
 go 1.22.0
+
+require (
+	github.com/andybalholm/brotli v1.2.5
+	github.com/klauspost/compress v1.18.0
+)
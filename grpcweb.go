@@ -0,0 +1,100 @@
+package requestbody
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	grpcWebHeaderLength = 5
+	// grpcWebTrailerFlag marks a frame as containing trailers rather than a message.
+	grpcWebTrailerFlag = 0x80
+	// grpcWebCompressedFlag marks a message frame as compressed using the Grpc-Encoding header.
+	grpcWebCompressedFlag = 0x01
+)
+
+// ReadGRPCWebMessage reads a gRPC-Web framed body, as sent with Content-Type: application/grpc-web,
+// returning the message frame and the optional trailer frame. Each frame has a 5-byte header with a
+// flags byte followed by the big-endian length of the frame.
+//
+// Frames are read from the decoded and limited body, and a frame declaring a length greater than the
+// content length limit returns a RequestContentTooLargeError before it's read. A malformed body
+// returns a BadRequestError. The frames count towards the MaxTotalBufferBytes limit. Compressed message
+// frames aren't decompressed, so return a RequestUnsupportedMediaTypeError. Errors are handled in the same
+// way as when reading from the body directly.
+func ReadGRPCWebMessage(r *http.Request) (message []byte, trailer []byte, err error) {
+	limit := int64(-1)
+	handleBodyError := func(err error) error { return err }
+	body, ok := bodyFromRequest(r)
+	if ok {
+		limit = body.options.maxContentLength
+		handleBodyError = body.handleError
+	}
+
+	readFrame := func() (flags byte, data []byte, err error) {
+		var header [grpcWebHeaderLength]byte
+		if _, err := io.ReadFull(r.Body, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return 0, nil, handleBodyError(&BadRequestError{
+					Err: errors.New("truncated gRPC-Web frame header"),
+				})
+			}
+			return 0, nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[1:]))
+		if limit > 0 && length > limit {
+			return 0, nil, handleBodyError(&RequestContentTooLargeError{
+				Limit: limit,
+			})
+		}
+		// The frame is held in memory, so counts towards the MaxTotalBufferBytes limit.
+		if err := body.chargeBuffer(length); err != nil {
+			return 0, nil, handleBodyError(err)
+		}
+		// Read incrementally rather than allocating the declared length, which is only 5 bytes to send.
+		data, err = io.ReadAll(io.LimitReader(r.Body, length))
+		if err != nil {
+			return 0, nil, err
+		}
+		if int64(len(data)) < length {
+			return 0, nil, handleBodyError(&BadRequestError{
+				Err: fmt.Errorf("truncated gRPC-Web frame: expected %d bytes", length),
+			})
+		}
+		return header[0], data, nil
+	}
+
+	for {
+		flags, data, err := readFrame()
+		if err == io.EOF {
+			if message == nil {
+				return nil, nil, handleBodyError(&BadRequestError{
+					Err: errors.New("missing gRPC-Web message frame"),
+				})
+			}
+			return message, trailer, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case flags&grpcWebTrailerFlag == 0 && flags&grpcWebCompressedFlag != 0:
+			return nil, nil, handleBodyError(&RequestUnsupportedMediaTypeError{
+				Encoding: "grpc-web",
+				Header:   "Grpc-Encoding",
+				Value:    r.Header.Get("Grpc-Encoding"),
+			})
+		case flags&grpcWebTrailerFlag != 0 && trailer == nil:
+			trailer = data
+		case flags&grpcWebTrailerFlag == 0 && message == nil && trailer == nil:
+			message = data
+		default:
+			return nil, nil, handleBodyError(&BadRequestError{
+				Err: errors.New("unexpected gRPC-Web frame"),
+			})
+		}
+	}
+}
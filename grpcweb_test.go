@@ -0,0 +1,122 @@
+package requestbody
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadGRPCWebMessage(t *testing.T) {
+	t.Parallel()
+
+	frame := func(flags byte, data []byte) []byte {
+		header := make([]byte, grpcWebHeaderLength, grpcWebHeaderLength+len(data))
+		header[0] = flags
+		binary.BigEndian.PutUint32(header[1:], uint32(len(data)))
+		return append(header, data...)
+	}
+	grpcWebHandler := func(w http.ResponseWriter, r *http.Request) {
+		message, trailer, err := ReadGRPCWebMessage(r)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Trailer", string(trailer))
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(message)
+	}
+
+	t.Run("message and trailer", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, grpcWebHandler)
+		body := append(frame(0, []byte("hello")), frame(grpcWebTrailerFlag, []byte("grpc-status: 0"))...)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, body))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "grpc-status: 0", response.Header.Get("X-Trailer"))
+		assertEqual(t, "hello", readString(t, response))
+	})
+
+	t.Run("message without trailer", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, grpcWebHandler)
+
+		response := postEncoded(t, ts, "", frame(0, []byte("hello")))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "hello", readString(t, response))
+	})
+
+	t.Run("frame over limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, grpcWebHandler, ContentLengthLimit(100))
+		// Only the header is sent, declaring a frame larger than the limit.
+		header := frame(0, nil)
+		binary.BigEndian.PutUint32(header[1:], 1000)
+
+		response := postEncoded(t, ts, "", header)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("truncated frame", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, grpcWebHandler)
+		body := frame(0, []byte("hello"))
+
+		response := postEncoded(t, ts, "", body[:len(body)-1])
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("huge declared length without limit", func(t *testing.T) {
+		t.Parallel()
+		// The declared length mustn't be allocated up front when there's no limit.
+		header := frame(0, nil)
+		binary.BigEndian.PutUint32(header[1:], 0xFFFFFFFF)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(header))
+
+		start := time.Now()
+		_, _, err := ReadGRPCWebMessage(req)
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the truncated frame to fail promptly, took %v", elapsed)
+		}
+	})
+
+	t.Run("compressed message", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _, err := ReadGRPCWebMessage(r)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(frame(grpcWebCompressedFlag, []byte("hello"))))
+		req.Header.Set("Grpc-Encoding", "gzip")
+		RequestBodyHandler(http.HandlerFunc(handler), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+		var unsupported *RequestUnsupportedMediaTypeError
+		assertEqual(t, true, errors.As(<-errs, &unsupported))
+		assertEqual(t, "grpc-web", unsupported.Encoding)
+		assertEqual(t, "Grpc-Encoding", unsupported.Header)
+		assertEqual(t, "gzip", unsupported.Value)
+		assertEqual(t, http.StatusUnsupportedMediaType, unsupported.RecommendedStatusCode())
+	})
+
+	t.Run("over total buffer cap", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, grpcWebHandler, MaxTotalBufferBytes(10))
+		body := append(frame(0, []byte("hello")), frame(grpcWebTrailerFlag, []byte("grpc-status: 0"))...)
+
+		response := postEncoded(t, ts, "", body)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
@@ -0,0 +1,48 @@
+package requestbody
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// HardenedProfile returns options enabling the anti-abuse features with strict defaults, limiting the
+// decoded body to maxBytes. This is intended as a starting point for public endpoints, so teams can opt
+// into strong protection with one call:
+//
+//	handler := requestbody.RequestBodyHandler(mux, requestbody.HardenedProfile(1024*1024)...)
+//
+// The profile:
+//   - limits the decoded body and the in-memory buffers held for it to maxBytes,
+//   - limits the bytes fed into decoders across the decode chain to twice maxBytes,
+//   - rejects encoded bodies which decoded to more than 100 times their raw size,
+//   - allows at most 2 content-codings from at most 4 Content-Encoding tokens, without parameters,
+//   - rejects requests with inconsistent framing headers, as AntiSmuggling does by default,
+//   - bounds the time spent constructing decoders to 10 seconds.
+//
+// The settings can be adjusted by passing options after the profile, as later options take precedence,
+// or using Options.With. HardenedProfile panics if maxBytes isn't positive.
+func HardenedProfile(maxBytes int64) Options {
+	if maxBytes <= 0 {
+		panic(fmt.Sprintf("requestbody: invalid hardened profile limit %d", maxBytes))
+	}
+	return Options{
+		ContentLengthLimit(maxBytes),
+		MaxTotalBufferBytes(maxBytes),
+		DecodeByteBudget(budgetFor(maxBytes)),
+		MaxFinalRatio(100),
+		MaxEncodingLayers(2),
+		MaxEncodingTokens(4),
+		StrictEncodingParsing(true),
+		AntiSmuggling(true),
+		InitTimeout(10 * time.Second),
+	}
+}
+
+// budgetFor returns twice the limit, saturating rather than overflowing.
+func budgetFor(maxBytes int64) int64 {
+	if maxBytes > math.MaxInt64/2 {
+		return math.MaxInt64
+	}
+	return maxBytes * 2
+}
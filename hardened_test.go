@@ -0,0 +1,106 @@
+package requestbody
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHardenedProfile(t *testing.T) {
+	t.Parallel()
+
+	const maxBytes = 64 * 1024
+	sourceData := []byte(`{"message":"The quick brown fox jumps over the lazy dog"}`)
+	handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), HardenedProfile(maxBytes)...)
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		return response
+	}
+	newRequest := func(encoding string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		return req
+	}
+
+	t.Run("legitimate request", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(newRequest("gzip", gzipBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	for _, tc := range []struct {
+		name     string
+		request  func(t *testing.T) *http.Request
+		expected int
+	}{
+		{"declared length too large", func(t *testing.T) *http.Request {
+			return newRequest("", bytes.Repeat([]byte("a"), maxBytes+1))
+		}, http.StatusRequestEntityTooLarge},
+		{"decompression bomb", func(t *testing.T) *http.Request {
+			return newRequest("gzip", gzipBytes(t, make([]byte, 100*maxBytes)))
+		}, http.StatusRequestEntityTooLarge},
+		{"high compression ratio", func(t *testing.T) *http.Request {
+			// Within the limit, but decoding to far more than plausible for the raw size.
+			return newRequest("gzip", gzipBytes(t, make([]byte, maxBytes)))
+		}, http.StatusRequestEntityTooLarge},
+		{"too many layers", func(t *testing.T) *http.Request {
+			return newRequest("gzip, gzip, gzip", gzipBytes(t, gzipBytes(t, gzipBytes(t, sourceData))))
+		}, http.StatusBadRequest},
+		{"too many tokens", func(t *testing.T) *http.Request {
+			return newRequest("identity, identity, identity, identity, gzip", gzipBytes(t, sourceData))
+		}, http.StatusBadRequest},
+		{"coding parameters", func(t *testing.T) *http.Request {
+			return newRequest("gzip;level=9", gzipBytes(t, sourceData))
+		}, http.StatusBadRequest},
+		{"smuggled length", func(t *testing.T) *http.Request {
+			req := newRequest("", sourceData)
+			req.Header.Set("Transfer-Encoding", "chunked")
+			req.Header.Set("Content-Length", "5")
+			return req
+		}, http.StatusBadRequest},
+		{"differing lengths", func(t *testing.T) *http.Request {
+			req := newRequest("", sourceData)
+			req.Header["Content-Length"] = []string{"5", "6"}
+			return req
+		}, http.StatusBadRequest},
+		{"unsupported encoding", func(t *testing.T) *http.Request {
+			return newRequest("compress", []byte(strings.Repeat("a", 10)))
+		}, http.StatusUnsupportedMediaType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			response := serve(tc.request(t))
+
+			assertEqual(t, tc.expected, response.Code)
+		})
+	}
+
+	t.Run("overridden by later options", func(t *testing.T) {
+		t.Parallel()
+		handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), HardenedProfile(maxBytes).With(MaxEncodingLayers(3))...)
+		response := httptest.NewRecorder()
+
+		handler.ServeHTTP(response, newRequest("gzip, gzip, gzip", gzipBytes(t, gzipBytes(t, gzipBytes(t, sourceData)))))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			assertEqual(t, true, recover() != nil)
+		}()
+
+		HardenedProfile(0)
+	})
+}
@@ -0,0 +1,248 @@
+package requestbody
+
+import (
+	"maps"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Info describes the request body metadata parsed by the RequestBodyHandler middleware.
+type Info struct {
+	// ContentLength is the declared length of the raw request body, or -1 if unknown.
+	ContentLength int64
+	// ContentType is the lowercase media type from the Content-Type header, without parameters.
+	ContentType string
+	// ContentTypeParams are the parameters from the Content-Type header, such as "charset".
+	ContentTypeParams map[string]string
+	// Encodings are the content-codings from the Content-Encoding header, in wire order, without any parameters.
+	Encodings []string
+	// MaxContentLength is the effective content length limit, or -1 if unlimited.
+	MaxContentLength int64
+	// RequireContentLength reports whether the request is required to declare its content length.
+	RequireContentLength bool
+}
+
+// RequestBodyInfo returns the parsed request body metadata for a request wrapped by the
+// RequestBodyHandler middleware. The second return value is false if the request wasn't wrapped.
+//
+// Headers are parsed once and cached on first use, while the limit fields reflect the options
+// in effect at the time of the call, including any per-request overrides.
+func RequestBodyInfo(r *http.Request) (Info, bool) {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return Info{}, false
+	}
+	headers := body.parsedHeaders()
+	return Info{
+		ContentLength:        body.contentLength,
+		ContentType:          headers.mediaType,
+		ContentTypeParams:    maps.Clone(headers.mediaTypeParams),
+		Encodings:            append([]string(nil), headers.encodings...),
+		MaxContentLength:     body.options.maxContentLength,
+		RequireContentLength: body.options.requireContentLength,
+	}, true
+}
+
+// Snapshot is a read-only copy of the effective options for a request, including any per-request overrides.
+type Snapshot struct {
+	// MaxContentLength is the effective content length limit, or -1 if unlimited.
+	MaxContentLength int64
+	// RequireContentLength reports whether the request is required to declare its content length.
+	RequireContentLength bool
+	// SupportedEncodings are the sorted names of the supported encodings, including aliases such as "x-gzip"
+	// which aren't advertised.
+	SupportedEncodings []string
+}
+
+// CurrentOptions returns a snapshot of the effective options for a request wrapped by the RequestBodyHandler
+// middleware, which helps confirm that options set using SetRequestBodyOption took effect before the body is
+// read. The second return value is false if the request wasn't wrapped.
+func CurrentOptions(r *http.Request) (Snapshot, bool) {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return Snapshot{}, false
+	}
+	encodings := make([]string, 0, len(body.options.supportedEncodings))
+	for name := range body.options.supportedEncodings {
+		encodings = append(encodings, name)
+	}
+	sort.Strings(encodings)
+	return Snapshot{
+		MaxContentLength:     body.options.maxContentLength,
+		RequireContentLength: body.options.requireContentLength,
+		SupportedEncodings:   encodings,
+	}, true
+}
+
+// BodyStats describes how the handler consumed the request body.
+type BodyStats struct {
+	// ReadCalls is the number of calls to Read on the body.
+	ReadCalls int
+	// MaxReadSize is the largest buffer passed to Read on the body.
+	MaxReadSize int
+}
+
+// RequestBodyStats returns statistics about how the request body has been read so far, which helps
+// identify handlers doing many tiny reads that should be buffered. It's safe to call concurrently with
+// reads of the body, such as from an OnBodyComplete callback to record the final statistics.
+// The second return value is false if the request wasn't wrapped by the RequestBodyHandler middleware.
+func RequestBodyStats(r *http.Request) (BodyStats, bool) {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return BodyStats{}, false
+	}
+	return body.stats.snapshot(), true
+}
+
+// bodyStats records BodyStats so they can be read concurrently with reads of the body.
+type bodyStats struct {
+	readCalls   atomic.Int64
+	maxReadSize atomic.Int64
+}
+
+func (s *bodyStats) record(size int) {
+	s.readCalls.Add(1)
+	for {
+		current := s.maxReadSize.Load()
+		if int64(size) <= current || s.maxReadSize.CompareAndSwap(current, int64(size)) {
+			return
+		}
+	}
+}
+
+func (s *bodyStats) snapshot() BodyStats {
+	return BodyStats{
+		ReadCalls:   int(s.readCalls.Load()),
+		MaxReadSize: int(s.maxReadSize.Load()),
+	}
+}
+
+// BytesRead returns the number of decoded bytes returned from the request body so far, which can be
+// used for logging and metrics once the handler has consumed the body. It's safe to call concurrently
+// with reads of the body, and returns 0 if the request wasn't wrapped by the RequestBodyHandler middleware.
+func BytesRead(r *http.Request) int64 {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return 0
+	}
+	return body.decodedBytes.Load()
+}
+
+// DecodeError returns the error which ended decoding of the body early when using the LenientDecode
+// option, or nil if decoding was successful or the request wasn't wrapped by the RequestBodyHandler middleware.
+func DecodeError(r *http.Request) error {
+	body, ok := bodyFromRequest(r)
+	if !ok || body.decodeErr == nil {
+		return nil
+	}
+	return body.decodeErr
+}
+
+// AppliedEncodings returns the lowercase codings decoded by the middleware, in wire order, excluding "identity".
+// This includes any transfer-codings decoded using DecodeTransferEncoding, after the content-codings.
+//
+// Once the body has been read, these are the codings of the constructed decode chain, or an empty slice if
+// the body was rejected before it was constructed, such as for an unsupported encoding. Before the body is
+// read, these are the codings which would be decoded using the current options, or an empty slice if the
+// encodings would be rejected. It returns an empty slice for requests without an encoding, and nil if the
+// request wasn't wrapped by the RequestBodyHandler middleware.
+func AppliedEncodings(r *http.Request) []string {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return nil
+	}
+	if body.initialized {
+		return append([]string{}, body.appliedEncodings...)
+	}
+	return body.previewEncodings()
+}
+
+// UnhandledEncoding returns the first unsupported coding of a body accepted using PassthroughUnknownEncoding,
+// which is left encoded along with the other codings, or an empty string if there's no unsupported coding.
+// Before the body is read, this is the coding which would be left encoded using the current options.
+// It returns an empty string if the request wasn't wrapped by the RequestBodyHandler middleware.
+func UnhandledEncoding(r *http.Request) string {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return ""
+	}
+	if body.initialized {
+		return body.unhandledEncoding
+	}
+	if !body.options.passthroughUnknownEncoding {
+		return ""
+	}
+	for _, token := range body.encodingTokens() {
+		if _, supported := body.options.decodableEncoding(strings.ToLower(token)); token != "" && !supported {
+			return token
+		}
+	}
+	return ""
+}
+
+// EffectiveErrorHandler returns the error handler currently configured for the request body, including any
+// per-request override. The handler is nil when errors are returned from reads, such as with ReturnOnError.
+// Handlers registered for specific kinds of error using HandleErrorKind take precedence over this handler.
+// The second return value is false if the request wasn't wrapped by the RequestBodyHandler middleware.
+func EffectiveErrorHandler(r *http.Request) (RequestBodyErrorHandler, bool) {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return nil, false
+	}
+	return body.options.handleError, true
+}
+
+// parsedHeaders holds the body related request headers after parsing.
+type parsedHeaders struct {
+	mediaType       string
+	mediaTypeParams map[string]string
+	encodings       []string
+}
+
+func (r *lazyReader) parsedHeaders() parsedHeaders {
+	r.headersOnce.Do(func() {
+		if r.contentType != "" {
+			mediaType, params, err := mime.ParseMediaType(r.contentType)
+			if err != nil && mediaType == "" {
+				// Fall back to the raw value so handlers can still inspect it.
+				mediaType, _, _ = strings.Cut(r.contentType, ";")
+				mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+			}
+			r.headers.mediaType = mediaType
+			r.headers.mediaTypeParams = params
+		}
+		if r.contentEncoding != "" {
+			for _, encoding := range strings.Split(r.contentEncoding, ",") {
+				// Content-codings don't have parameters, but some clients wrongly send quality values,
+				// such as "gzip;q=1.0", so any parameters are ignored rather than rejecting the coding.
+				encoding, _, _ = strings.Cut(encoding, ";")
+				r.headers.encodings = append(r.headers.encodings, strings.TrimSpace(encoding))
+			}
+		}
+	})
+	return r.headers
+}
+
+// previewEncodings resolves the codings which init would decode using the current options, without
+// constructing any decoders.
+func (r *lazyReader) previewEncodings() []string {
+	applied := []string{}
+	if r.contentLength == 0 || (r.options.strictEncodingParsing && strings.Contains(r.contentEncoding, ";")) {
+		return applied
+	}
+	tokens := r.encodingTokens()
+	for _, token := range tokens {
+		name := strings.ToLower(token)
+		encoder, supported := r.options.decodableEncoding(name)
+		if !supported || (encoder.nonChainable && len(tokens) > 1) {
+			return []string{} // The body will be rejected.
+		}
+		if !encoder.identity {
+			applied = append(applied, name)
+		}
+	}
+	return applied
+}
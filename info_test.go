@@ -0,0 +1,411 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRequestBodyInfo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("compressed typed request", func(t *testing.T) {
+		t.Parallel()
+		infos := make(chan Info, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			info, ok := RequestBodyInfo(r)
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			infos <- info
+			w.WriteHeader(http.StatusOK)
+		}
+		ts := setupServer(t, handler, ContentLengthLimit(1024), RequireContentLength(true))
+		body := gzipBytes(t, []byte(`{"hello":"world"}`))
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+		assertNoError(t, err)
+		req.Header.Set("Content-Type", "Application/JSON; charset=utf-8")
+		req.Header.Set("Content-Encoding", "deflate , gzip")
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		info := <-infos
+		assertEqual(t, Info{
+			ContentLength:        int64(len(body)),
+			ContentType:          "application/json",
+			ContentTypeParams:    map[string]string{"charset": "utf-8"},
+			Encodings:            []string{"deflate", "gzip"},
+			MaxContentLength:     1024,
+			RequireContentLength: true,
+		}, info)
+	})
+
+	t.Run("not wrapped", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+		assertNoError(t, err)
+
+		_, ok := RequestBodyInfo(req)
+		assertEqual(t, false, ok)
+	})
+}
+
+func TestRequestBodyStats(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fixed chunk reads", func(t *testing.T) {
+		t.Parallel()
+		type readResult struct {
+			stats BodyStats
+			calls int
+		}
+		results := make(chan readResult, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			chunk := make([]byte, 10)
+			calls := 0
+			for {
+				calls++
+				if _, err := r.Body.Read(chunk); err != nil {
+					break
+				}
+			}
+			stats, _ := RequestBodyStats(r)
+			results <- readResult{stats, calls}
+			w.WriteHeader(http.StatusOK)
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 100)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		result := <-results
+		if result.calls < 10 {
+			t.Errorf("Expected at least 10 reads of 10 bytes, got %d", result.calls)
+		}
+		assertEqual(t, BodyStats{ReadCalls: result.calls, MaxReadSize: 10}, result.stats)
+	})
+
+	t.Run("from body complete callback", func(t *testing.T) {
+		t.Parallel()
+		results := make(chan BodyStats, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			chunk := make([]byte, 16)
+			for {
+				if _, err := r.Body.Read(chunk); err != nil {
+					break
+				}
+			}
+		}
+		callback := func(r *http.Request, bytesRead int64, encoding string) {
+			stats, ok := RequestBodyStats(r)
+			assertEqual(t, true, ok)
+			results <- stats
+		}
+		ts := setupServer(t, handler, OnBodyComplete(callback))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 64)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		stats := <-results
+		assertEqual(t, 16, stats.MaxReadSize)
+		if stats.ReadCalls < 4 {
+			t.Errorf("Expected at least 4 reads of 16 bytes, got %d", stats.ReadCalls)
+		}
+	})
+
+	t.Run("concurrent with reads", func(t *testing.T) {
+		t.Parallel()
+		results := make(chan BodyStats, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					_, _ = RequestBodyStats(r)
+				}
+			}()
+			_, _ = io.ReadAll(r.Body)
+			<-done
+			stats, _ := RequestBodyStats(r)
+			results <- stats
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "", make([]byte, 1000))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		if stats := <-results; stats.ReadCalls == 0 {
+			t.Errorf("Expected reads to be recorded")
+		}
+	})
+}
+
+func TestBytesRead(t *testing.T) {
+	t.Parallel()
+
+	t.Run("decoded bytes after reading", func(t *testing.T) {
+		t.Parallel()
+		counts := make(chan int64, 2)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			counts <- BytesRead(r)
+			_, _ = io.ReadAll(r.Body)
+			counts <- BytesRead(r)
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 1000)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, int64(0), <-counts)
+		assertEqual(t, int64(1000), <-counts)
+	})
+
+	t.Run("concurrent with reads", func(t *testing.T) {
+		t.Parallel()
+		counts := make(chan int64, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					_ = BytesRead(r)
+				}
+			}()
+			chunk := make([]byte, 7)
+			for {
+				if _, err := r.Body.Read(chunk); err != nil {
+					break
+				}
+			}
+			<-done
+			counts <- BytesRead(r)
+		}
+		ts := setupServer(t, handler)
+
+		response := postEncoded(t, ts, "", make([]byte, 1000))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, int64(1000), <-counts)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		assertNoError(t, err)
+
+		assertEqual(t, int64(0), BytesRead(req))
+	})
+}
+
+func TestEffectiveErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	type result struct {
+		handler RequestBodyErrorHandler
+		ok      bool
+	}
+	custom := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	sameHandler := func(expected, actual RequestBodyErrorHandler) bool {
+		return reflect.ValueOf(expected).Pointer() == reflect.ValueOf(actual).Pointer()
+	}
+	serve := func(override []Option, defaults ...Option) (before, after result) {
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			before.handler, before.ok = EffectiveErrorHandler(r)
+			SetRequestBodyOption(r, override...)
+			after.handler, after.ok = EffectiveErrorHandler(r)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		RequestBodyHandler(http.HandlerFunc(handler), defaults...).ServeHTTP(httptest.NewRecorder(), req)
+		return before, after
+	}
+
+	t.Run("per-request override", func(t *testing.T) {
+		t.Parallel()
+		before, after := serve([]Option{HandleRequestBodyError(custom)})
+
+		assertEqual(t, true, before.ok)
+		assertEqual(t, true, sameHandler(StatusOnlyRequestBodyErrorHandler, before.handler))
+		assertEqual(t, true, after.ok)
+		assertEqual(t, true, sameHandler(custom, after.handler))
+	})
+
+	t.Run("return mode", func(t *testing.T) {
+		t.Parallel()
+		before, after := serve([]Option{ReturnOnError()}, HandleRequestBodyError(custom))
+
+		assertEqual(t, true, sameHandler(custom, before.handler))
+		assertEqual(t, true, after.ok)
+		assertEqual(t, true, after.handler == nil)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+
+		handler, ok := EffectiveErrorHandler(req)
+		assertEqual(t, false, ok)
+		assertEqual(t, true, handler == nil)
+	})
+}
+
+func TestAppliedEncodings(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, req *http.Request, opts ...Option) (before, after []string, err error) {
+		t.Helper()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			before = AppliedEncodings(r)
+			_, err = io.ReadAll(r.Body)
+			after = AppliedEncodings(r)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return before, after, err
+	}
+	newRequest := func(encoding string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		return req
+	}
+
+	t.Run("stacked encodings", func(t *testing.T) {
+		t.Parallel()
+
+		before, after, err := serve(t, newRequest(" Deflate , GZIP", gzipBytes(t, deflateBytes(t, sourceData))))
+
+		assertNoError(t, err)
+		assertEqual(t, []string{"deflate", "gzip"}, before)
+		assertEqual(t, []string{"deflate", "gzip"}, after)
+	})
+
+	t.Run("identity", func(t *testing.T) {
+		t.Parallel()
+
+		before, after, err := serve(t, newRequest("identity, gzip", gzipBytes(t, sourceData)))
+
+		assertNoError(t, err)
+		assertEqual(t, []string{"gzip"}, before)
+		assertEqual(t, []string{"gzip"}, after)
+	})
+
+	t.Run("no encoding", func(t *testing.T) {
+		t.Parallel()
+
+		before, after, _ := serve(t, newRequest("", sourceData))
+
+		assertEqual(t, []string{}, before)
+		assertEqual(t, []string{}, after)
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		t.Parallel()
+
+		before, after, err := serve(t, newRequest("gzip, compress", sourceData))
+
+		var unsupported *RequestUnsupportedMediaTypeError
+		assertEqual(t, true, errors.As(err, &unsupported))
+		assertEqual(t, []string{}, before)
+		assertEqual(t, []string{}, after)
+	})
+
+	t.Run("transfer codings", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest("deflate", gzipBytes(t, deflateBytes(t, sourceData)))
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"gzip", "chunked"}
+
+		before, after, err := serve(t, req, DecodeTransferEncoding(true))
+
+		assertNoError(t, err)
+		assertEqual(t, []string{"deflate", "gzip"}, before)
+		assertEqual(t, []string{"deflate", "gzip"}, after)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+
+		assertEqual(t, true, AppliedEncodings(req) == nil)
+	})
+}
+
+func TestCurrentOptions(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, handler http.HandlerFunc, opts ...Option) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		RequestBodyHandler(handler, opts...).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		snapshots := make(chan Snapshot, 1)
+
+		serve(t, func(w http.ResponseWriter, r *http.Request) {
+			snapshot, _ := CurrentOptions(r)
+			snapshots <- snapshot
+		})
+
+		assertEqual(t, Snapshot{
+			MaxContentLength:     10 * 1024 * 1024,
+			RequireContentLength: false,
+			SupportedEncodings:   defaultEncodings("identity", "x-gzip"),
+		}, <-snapshots)
+	})
+
+	t.Run("per-request overrides", func(t *testing.T) {
+		t.Parallel()
+		snapshots := make(chan Snapshot, 1)
+
+		serve(t, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, ContentLengthLimit(100), RequireContentLength(true), DisableEncoding("br"))
+			snapshot, _ := CurrentOptions(r)
+			snapshots <- snapshot
+		}, DisableEncoding("x-gzip"))
+
+		assertEqual(t, Snapshot{
+			MaxContentLength:     100,
+			RequireContentLength: true,
+			SupportedEncodings:   slices.DeleteFunc(defaultEncodings("identity"), func(coding string) bool { return coding == "br" }),
+		}, <-snapshots)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		t.Parallel()
+		limits := make(chan int64, 1)
+
+		serve(t, func(w http.ResponseWriter, r *http.Request) {
+			snapshot, _ := CurrentOptions(r)
+			snapshot.SupportedEncodings[0] = "changed"
+			snapshot, _ = CurrentOptions(r)
+			assertEqual(t, defaultEncodings("identity", "x-gzip")[0], snapshot.SupportedEncodings[0])
+			limits <- snapshot.MaxContentLength
+		})
+
+		assertEqual(t, int64(10*1024*1024), <-limits)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+
+		_, ok := CurrentOptions(req)
+
+		assertEqual(t, false, ok)
+	})
+}
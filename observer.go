@@ -0,0 +1,69 @@
+package requestbody
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Observer receives events about request bodies processed by the RequestBodyHandler middleware, such
+// as to record metrics. Unlike HandleRequestBodyError, observers don't write the response, and are
+// notified of errors even when using ReturnOnError.
+//
+// Methods are called from the goroutine reading the body, so should return quickly.
+type Observer interface {
+	// BodyRejected is called once per request with the first RequestBodyError which stopped the body
+	// from being read, including errors found before reading such as an unsupported encoding.
+	BodyRejected(r *http.Request, err RequestBodyError)
+	// BodyDecoded is called once the body has been read to the end without error, along with the
+	// Content-Encoding header of the request, the number of raw and decoded bytes read, and the time
+	// spent reading from the decode chain. It's called for bodies without an encoding too, in which
+	// case the encoding is empty.
+	BodyDecoded(r *http.Request, encoding string, rawBytes, decodedBytes int64, dur time.Duration)
+}
+
+// WithObserver registers an Observer which is notified when request bodies are decoded or rejected.
+// Reads aren't timed when no observer is set.
+func WithObserver(o Observer) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.observer = o
+		},
+	}
+}
+
+// handleError notifies the observer of the error, if it's a RequestBodyError, before handling it
+// using the most specific error handler for the request.
+func (r *lazyReader) handleError(err error) error {
+	if tooLarge := (*RequestContentTooLargeError)(nil); errors.As(err, &tooLarge) && tooLarge.status == 0 {
+		// The limits are enforced by readers without the options, so the status is only set once reported.
+		tooLarge.status = r.options.contentTooLargeStatus
+	}
+	r.observeRejected(err)
+	handler := r.options.errorHandlerFor(err)
+	if _, ok := err.(RequestBodyError); ok && handler != nil {
+		r.handled = true
+	}
+	return handleError(handler, err)
+}
+
+// observeRejected notifies the observer of the first RequestBodyError for the request.
+func (r *lazyReader) observeRejected(err error) {
+	var bodyErr RequestBodyError
+	if r.options.observer != nil && !r.rejected && errors.As(err, &bodyErr) {
+		r.rejected = true
+		r.options.observer.BodyRejected(r.request, bodyErr)
+	}
+}
+
+// observeDecoded notifies the observer that the body was read to the end.
+func (r *lazyReader) observeDecoded() {
+	if r.options.observer == nil {
+		return
+	}
+	var rawBytes int64
+	if r.raw != nil {
+		rawBytes = r.raw.n
+	}
+	r.options.observer.BodyDecoded(r.request, r.contentEncoding, rawBytes, r.decodedBytes.Load(), r.decodeDuration)
+}
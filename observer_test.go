@@ -0,0 +1,138 @@
+package requestbody
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type observerEvent struct {
+	rejected     RequestBodyError
+	encoding     string
+	rawBytes     int64
+	decodedBytes int64
+	dur          time.Duration
+	wrapped      bool
+}
+
+// recordingObserver records the events it's notified of.
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []observerEvent
+}
+
+func (o *recordingObserver) BodyRejected(r *http.Request, err RequestBodyError) {
+	_, wrapped := bodyFromRequest(r)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, observerEvent{rejected: err, wrapped: wrapped})
+}
+
+func (o *recordingObserver) BodyDecoded(r *http.Request, encoding string, rawBytes, decodedBytes int64, dur time.Duration) {
+	_, wrapped := bodyFromRequest(r)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, observerEvent{
+		encoding:     encoding,
+		rawBytes:     rawBytes,
+		decodedBytes: decodedBytes,
+		dur:          dur,
+		wrapped:      wrapped,
+	})
+}
+
+func (o *recordingObserver) recorded() []observerEvent {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]observerEvent(nil), o.events...)
+}
+
+func TestWithObserver(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("decoded", func(t *testing.T) {
+		t.Parallel()
+		observer := &recordingObserver{}
+		ts := setupServer(t, echoHandler(), WithObserver(observer))
+		encoded := gzipBytes(t, sourceData)
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		events := observer.recorded()
+		assertEqual(t, 1, len(events))
+		assertEqual(t, nil, events[0].rejected)
+		assertEqual(t, "gzip", events[0].encoding)
+		assertEqual(t, int64(len(encoded)), events[0].rawBytes)
+		assertEqual(t, int64(len(sourceData)), events[0].decodedBytes)
+		assertEqual(t, true, events[0].dur > 0)
+		assertEqual(t, true, events[0].wrapped)
+	})
+
+	t.Run("unencoded", func(t *testing.T) {
+		t.Parallel()
+		observer := &recordingObserver{}
+		ts := setupServer(t, echoHandler(), WithObserver(observer))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		events := observer.recorded()
+		assertEqual(t, 1, len(events))
+		assertEqual(t, "", events[0].encoding)
+		assertEqual(t, int64(len(sourceData)), events[0].rawBytes)
+	})
+
+	t.Run("rejected once", func(t *testing.T) {
+		t.Parallel()
+		observer := &recordingObserver{}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			// Read repeatedly after the error, which is only observed once.
+			_, _ = io.ReadAll(r.Body)
+			_, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		ts := setupServer(t, handler, WithObserver(observer), ReturnOnError())
+
+		response := postEncoded(t, ts, "unknown", sourceData)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		events := observer.recorded()
+		assertEqual(t, 1, len(events))
+		assertEqual(t, http.StatusUnsupportedMediaType, events[0].rejected.RecommendedStatusCode())
+		assertEqual(t, true, events[0].wrapped)
+	})
+
+	t.Run("rejected with error handler", func(t *testing.T) {
+		t.Parallel()
+		observer := &recordingObserver{}
+		ts := setupServer(t, echoHandler(), WithObserver(observer), ContentLengthLimit(10))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+		events := observer.recorded()
+		assertEqual(t, 1, len(events))
+		assertEqual(t, http.StatusRequestEntityTooLarge, events[0].rejected.RecommendedStatusCode())
+	})
+
+	t.Run("rejected by helper", func(t *testing.T) {
+		t.Parallel()
+		observer := &recordingObserver{}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_ = EachLine(r, func([]byte) error { return nil }, 4)
+		}
+		ts := setupServer(t, handler, WithObserver(observer))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		events := observer.recorded()
+		assertEqual(t, 1, len(events))
+		assertEqual(t, http.StatusBadRequest, events[0].rejected.RecommendedStatusCode())
+	})
+}
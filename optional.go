@@ -0,0 +1,43 @@
+package requestbody
+
+// The Brotli and Zstandard decoders depend on third-party modules, which can be excluded from the build
+// using the requestbody_nobrotli and requestbody_nozstd build tags, such as to reduce the binary size:
+//
+//	go build -tags requestbody_nobrotli,requestbody_nozstd
+//
+// When excluded, the coding isn't supported by default, and requests using it are rejected with a
+// RequestUnsupportedMediaTypeError.
+
+// SupportBrotli adds support for the Brotli ("br") encoding, such as to restore it after DisableEncoding.
+// When the decoder is excluded using the requestbody_nobrotli build tag, the encoding isn't advertised and
+// requests using it are rejected with a RequestUnsupportedMediaTypeError, rather than failing to build.
+func SupportBrotli() Option {
+	return supportOptionalEncoding("br", BrotliEncodingReader, brotliAvailable)
+}
+
+// SupportZstd adds support for the Zstandard ("zstd") encoding, such as to restore it after DisableEncoding.
+// When the decoder is excluded using the requestbody_nozstd build tag, the encoding isn't advertised and
+// requests using it are rejected with a RequestUnsupportedMediaTypeError, rather than failing to build.
+func SupportZstd() Option {
+	return supportOptionalEncoding("zstd", ZstdEncodingReader, zstdAvailable)
+}
+
+func supportOptionalEncoding(name string, reader EncodingReader, available bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.setEncoding(name, &encoding{
+				reader:      reader,
+				unavailable: !available,
+			})
+		},
+	}
+}
+
+// unavailableEncodingError is returned by the readers of encodings excluded from the build.
+func unavailableEncodingError(name string) *RequestUnsupportedMediaTypeError {
+	return &RequestUnsupportedMediaTypeError{
+		Encoding: name,
+		Header:   "Content-Encoding",
+		Value:    name,
+	}
+}
@@ -0,0 +1,54 @@
+package requestbody
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Run with -tags requestbody_nobrotli,requestbody_nozstd to test the decoders being excluded.
+func TestSupportOptionalEncodings(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, tc := range []struct {
+		name      string
+		coding    string
+		option    Option
+		available bool
+		encode    func(t *testing.T, data []byte) []byte
+	}{
+		{"brotli", "br", SupportBrotli(), brotliAvailable, func(t *testing.T, data []byte) []byte { return brotliBytes(t, data) }},
+		{"zstd", "zstd", SupportZstd(), zstdAvailable, func(t *testing.T, data []byte) []byte { return zstdBytes(t, data) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), DisableEncoding(tc.coding), tc.option)
+
+			response := postEncoded(t, ts, tc.coding, tc.encode(t, sourceData))
+
+			if tc.available {
+				assertEqual(t, http.StatusOK, response.StatusCode)
+				assertEqual(t, string(sourceData), readString(t, response))
+			} else {
+				assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+			}
+		})
+
+		t.Run(tc.name+" advertised when available", func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), DisableEncoding(tc.coding), tc.option)
+
+			req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+			assertNoError(t, err)
+			response, err := http.DefaultClient.Do(req)
+			assertNoError(t, err)
+			defer response.Body.Close()
+
+			advertised := strings.Split(response.Header.Get("Accept-Encoding"), ", ")
+			assertEqual(t, tc.available, slices.Contains(advertised, tc.coding))
+		})
+	}
+}
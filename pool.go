@@ -0,0 +1,108 @@
+package requestbody
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sync"
+)
+
+// errDecoderClosed is returned when reading from a pooled decoder after it has been closed.
+var errDecoderClosed = errors.New("requestbody: read from closed decoder")
+
+var (
+	gzipReaderPool  sync.Pool
+	flateReaderPool sync.Pool
+)
+
+// gzipReader is a *gzip.Reader taken from the pool, which is returned to the pool when closed.
+type gzipReader struct {
+	reader *gzip.Reader
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	if z, ok := gzipReaderPool.Get().(*gzip.Reader); ok {
+		if err := z.Reset(r); err != nil {
+			gzipReaderPool.Put(z)
+			return nil, err
+		}
+		return &gzipReader{reader: z}, nil
+	}
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipReader{reader: z}, nil
+}
+
+func (g *gzipReader) Read(p []byte) (int, error) {
+	if g.reader == nil {
+		return 0, errDecoderClosed
+	}
+	return g.reader.Read(p)
+}
+
+// Close returns the reader to the pool, after which it can't be read. Closing more than once does nothing.
+func (g *gzipReader) Close() error {
+	if g.reader == nil {
+		return nil
+	}
+	err := g.reader.Close()
+	gzipReaderPool.Put(g.reader)
+	g.reader = nil
+	return err
+}
+
+// flateReader is a flate decompressor taken from the pool, which is returned to the pool when closed.
+type flateReader struct {
+	reader io.ReadCloser
+}
+
+func newFlateReader(r io.Reader) io.ReadCloser {
+	if f, ok := flateReaderPool.Get().(io.ReadCloser); ok {
+		// Resetting a flate decompressor without a dictionary can't fail.
+		_ = f.(flate.Resetter).Reset(r, nil)
+		return &flateReader{reader: f}
+	}
+	return &flateReader{reader: flate.NewReader(r)}
+}
+
+func (f *flateReader) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, errDecoderClosed
+	}
+	return f.reader.Read(p)
+}
+
+// Close returns the reader to the pool, after which it can't be read. Closing more than once does nothing.
+func (f *flateReader) Close() error {
+	if f.reader == nil {
+		return nil
+	}
+	err := f.reader.Close()
+	flateReaderPool.Put(f.reader)
+	f.reader = nil
+	return err
+}
+
+// asGzipReader returns the *gzip.Reader of a decoder, whether from GZipEncodingReader or a custom
+// EncodingReader which returns a *gzip.Reader directly.
+func asGzipReader(decoder io.ReadCloser) (*gzip.Reader, bool) {
+	switch decoder := decoder.(type) {
+	case *gzipReader:
+		return decoder.reader, decoder.reader != nil
+	case *gzip.Reader:
+		return decoder, true
+	}
+	return nil, false
+}
+
+// isPooled returns true if the decoder is returned to a pool when closed.
+func isPooled(decoder io.ReadCloser) bool {
+	switch decoder.(type) {
+	case *gzipReader, *flateReader:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+package requestbody
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPooledDecoders(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	readers := []struct {
+		name   string
+		reader EncodingReader
+		encode func(t *testing.T, data []byte) []byte
+	}{
+		{"gzip", GZipEncodingReader, gzipBytes},
+		{"deflate", DeflateEncodingReader, deflateBytes},
+	}
+
+	for _, tc := range readers {
+		t.Run(tc.name+" reused decoders", func(t *testing.T) {
+			t.Parallel()
+			for i := 0; i < 5; i++ {
+				data := append([]byte{byte(i)}, sourceData...)
+				reader, err := tc.reader(bytes.NewReader(tc.encode(t, data)))
+				assertNoError(t, err)
+				decoded, err := io.ReadAll(reader)
+				assertNoError(t, err)
+				assertEqual(t, data, decoded)
+				assertNoError(t, reader.Close())
+			}
+		})
+
+		t.Run(tc.name+" concurrent decoders", func(t *testing.T) {
+			t.Parallel()
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				data := append([]byte{byte(i)}, sourceData...)
+				encoded := tc.encode(t, data)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					reader, err := tc.reader(bytes.NewReader(encoded))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer reader.Close()
+					decoded, err := io.ReadAll(reader)
+					if err != nil || !bytes.Equal(data, decoded) {
+						t.Errorf("unexpected decode: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+
+		t.Run(tc.name+" can't be read after close", func(t *testing.T) {
+			t.Parallel()
+			reader, err := tc.reader(bytes.NewReader(tc.encode(t, sourceData)))
+			assertNoError(t, err)
+			assertNoError(t, reader.Close())
+			assertNoError(t, reader.Close())
+			_, err = reader.Read(make([]byte, 1))
+			if !errors.Is(err, errDecoderClosed) {
+				t.Fatalf("expected errDecoderClosed, got %v", err)
+			}
+		})
+	}
+
+	t.Run("invalid gzip header", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < 2; i++ {
+			_, err := GZipEncodingReader(strings.NewReader("this is not gzip data"))
+			if !errors.Is(err, gzip.ErrHeader) {
+				t.Fatalf("expected gzip.ErrHeader, got %v", err)
+			}
+		}
+	})
+
+	t.Run("middleware releases every layer", func(t *testing.T) {
+		t.Parallel()
+		var body *lazyReader
+		handler := RequestBodyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ = bodyFromRequest(r)
+			decoded, err := io.ReadAll(r.Body)
+			assertNoError(t, err)
+			assertEqual(t, sourceData, decoded)
+			assertEqual(t, 2, len(body.pooled))
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, deflateBytes(t, sourceData))))
+		req.Header.Set("Content-Encoding", "deflate, gzip")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		assertEqual(t, 0, len(body.pooled))
+	})
+}
+
+func BenchmarkPooledGzip(b *testing.B) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100))
+	_ = gz.Close()
+	body := buf.Bytes()
+	unpooled := func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}
+	for _, bc := range []struct {
+		name   string
+		reader EncodingReader
+	}{
+		{"pooled", GZipEncodingReader},
+		{"unpooled", unpooled},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), SupportEncoding("gzip", bc.reader))
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+					req.Header.Set("Content-Encoding", "gzip")
+					handler.ServeHTTP(httptest.NewRecorder(), req)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package requestbody
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// problemDetails is the JSON representation of a problem as defined by RFC 9457.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9457.html
+type problemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Extension members for RequestUnsupportedMediaTypeError.
+	Supported []string `json:"supported,omitempty"`
+	Requested string   `json:"requested,omitempty"`
+	// Extension members for RequestContentTooLargeError.
+	Limit *int64 `json:"limit,omitempty"`
+	Read  *int64 `json:"read,omitempty"`
+}
+
+// DetailedProblemJSONHandler is an error handler which writes an application/problem+json
+// response with extension members describing the error:
+//   - RequestUnsupportedMediaTypeError includes "supported" and "requested".
+//   - RequestContentTooLargeError includes "limit" and "read".
+//
+// Nothing is written if the handler has already sent the response status.
+func DetailedProblemJSONHandler(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+	status := err.RecommendedStatusCode()
+	problem := problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+	}
+	switch e := err.(type) {
+	case *RequestUnsupportedMediaTypeError:
+		problem.Supported = e.Supported
+		problem.Requested = e.Encoding
+	case *RequestContentTooLargeError:
+		problem.Limit = &e.Limit
+		problem.Read = &e.Read
+	}
+	writeProblem(w, problem)
+}
+
+// ProblemDetailsErrorHandler is an error handler which writes an application/problem+json response as
+// defined by RFC 9457, with the "type", "title", "status" and "detail" members derived from the error, as
+// a ready-made alternative to StatusOnlyRequestBodyErrorHandler. The status is the error's recommended
+// status code, and RequestContentTooLargeError includes the "limit" extension member.
+// Use DetailedProblemJSONHandler to include further extension members.
+//
+// Nothing is written if the handler has already sent the response status.
+func ProblemDetailsErrorHandler(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+	status := err.RecommendedStatusCode()
+	problem := problemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: err.Error(),
+	}
+	if tooLarge, ok := err.(*RequestContentTooLargeError); ok {
+		problem.Limit = &tooLarge.Limit
+	}
+	writeProblem(w, problem)
+}
+
+func writeProblem(w http.ResponseWriter, problem problemDetails) {
+	if responseCommitted(w) {
+		return // The handler already sent a status, so the problem can't be written.
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
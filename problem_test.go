@@ -0,0 +1,136 @@
+package requestbody
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestDetailedProblemJSONHandler(t *testing.T) {
+	t.Parallel()
+
+	decodeProblem := func(t *testing.T, response *http.Response) map[string]any {
+		t.Helper()
+		assertEqual(t, "application/problem+json", response.Header.Get("Content-Type"))
+		var problem map[string]any
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&problem))
+		return problem
+	}
+
+	t.Run("unsupported media type", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleRequestBodyError(DetailedProblemJSONHandler))
+
+		response := postEncoded(t, ts, "compress", []byte("data"))
+
+		var supported []any
+		for _, coding := range defaultEncodings() {
+			supported = append(supported, coding)
+		}
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+		assertEqual(t, map[string]any{
+			"type":      "about:blank",
+			"title":     "Unsupported Media Type",
+			"status":    float64(http.StatusUnsupportedMediaType),
+			"detail":    "Unsupported Media Type: compress",
+			"supported": supported,
+			"requested": "compress",
+		}, decodeProblem(t, response))
+	})
+
+	t.Run("content too large", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(100), HandleRequestBodyError(DetailedProblemJSONHandler))
+
+		// Send without a length so the limit is exceeded while reading.
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBuffer(make([]byte, 101)))
+		assertNoError(t, err)
+		req.ContentLength = -1
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+		assertEqual(t, map[string]any{
+			"type":   "about:blank",
+			"title":  "Request Entity Too Large",
+			"status": float64(http.StatusRequestEntityTooLarge),
+			"detail": "Content Too Large: greater than 100 bytes",
+			"limit":  float64(100),
+			"read":   float64(100),
+		}, decodeProblem(t, response))
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleRequestBodyError(DetailedProblemJSONHandler))
+
+		response := postEncoded(t, ts, "gzip", []byte("not gzip"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		problem := decodeProblem(t, response)
+		assertEqual(t, "Bad Request", problem["title"])
+		assertEqual(t, nil, problem["limit"])
+		assertEqual(t, nil, problem["supported"])
+	})
+}
+
+func TestProblemDetailsErrorHandler(t *testing.T) {
+	t.Parallel()
+
+	decodeProblem := func(t *testing.T, response *http.Response) map[string]any {
+		t.Helper()
+		assertEqual(t, "application/problem+json", response.Header.Get("Content-Type"))
+		var problem map[string]any
+		assertNoError(t, json.NewDecoder(response.Body).Decode(&problem))
+		return problem
+	}
+
+	t.Run("content too large", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(100), HandleRequestBodyError(ProblemDetailsErrorHandler))
+
+		response := postEncoded(t, ts, "", make([]byte, 101))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+		assertEqual(t, map[string]any{
+			"type":   "about:blank",
+			"title":  "Request Entity Too Large",
+			"status": float64(http.StatusRequestEntityTooLarge),
+			"detail": "Content Too Large: greater than 100 bytes",
+			"limit":  float64(100),
+		}, decodeProblem(t, response))
+	})
+
+	t.Run("unsupported media type", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleRequestBodyError(ProblemDetailsErrorHandler))
+
+		response := postEncoded(t, ts, "compress", []byte("data"))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+		assertEqual(t, map[string]any{
+			"type":   "about:blank",
+			"title":  "Unsupported Media Type",
+			"status": float64(http.StatusUnsupportedMediaType),
+			"detail": "Unsupported Media Type: compress",
+		}, decodeProblem(t, response))
+	})
+
+	t.Run("overridden status", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), RequireContentLength(true), LengthRequiredStatus(http.StatusBadRequest),
+			HandleRequestBodyError(ProblemDetailsErrorHandler))
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewBufferString("data"))
+		assertNoError(t, err)
+		req.ContentLength = -1
+
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		assertEqual(t, float64(http.StatusBadRequest), decodeProblem(t, response)["status"])
+	})
+}
@@ -0,0 +1,44 @@
+package requestbody
+
+import (
+	"io"
+	"math"
+	"net/http"
+)
+
+// ReadAll reads the whole decoded and limited request body, returning any RequestBodyError, such as a
+// RequestContentTooLargeError, as the error rather than handling it.
+//
+// When the middleware is configured with any error handlers, ReadAll behaves as if ReturnOnError was set for
+// the duration of the call, so the error handler isn't called and no response is written. This lets the
+// caller decide how to respond. The configured error handler applies again to later reads of the body.
+// Requests which weren't wrapped by the RequestBodyHandler middleware are read as-is.
+//
+// The returned data counts towards the MaxTotalBufferBytes limit for the request, returning a
+// RequestContentTooLargeError if the body doesn't fit within the remaining limit.
+func ReadAll(r *http.Request) ([]byte, error) {
+	body, ok := bodyFromRequest(r)
+	if !ok {
+		return io.ReadAll(r.Body)
+	}
+	handler, kindHandlers := body.options.handleError, body.options.kindErrorHandlers
+	body.options.handleError, body.options.kindErrorHandlers = nil, nil
+	defer func() {
+		body.options.handleError, body.options.kindErrorHandlers = handler, kindHandlers
+	}()
+
+	allowed, limit := body.bufferLimit(math.MaxInt64)
+	data, fits, err := readAtMost(r.Body, allowed)
+	if err != nil {
+		return nil, err
+	}
+	if !fits {
+		return nil, &RequestContentTooLargeError{
+			Limit:  limit,
+			Read:   int64(len(data)),
+			status: body.options.contentTooLargeStatus,
+		}
+	}
+	body.bufferedBytes += int64(len(data))
+	return data, nil
+}
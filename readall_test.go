@@ -0,0 +1,112 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadAll(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	type result struct {
+		data            []byte
+		err             error
+		hasErrorHandler bool
+	}
+	serve := func(t *testing.T, req *http.Request, opts ...Option) (result, *httptest.ResponseRecorder) {
+		t.Helper()
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			data, err := ReadAll(r)
+			errorHandler, _ := EffectiveErrorHandler(r)
+			results <- result{data: data, err: err, hasErrorHandler: errorHandler != nil}
+			if err != nil {
+				w.WriteHeader(http.StatusTeapot)
+			}
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(handler), opts...).ServeHTTP(response, req)
+		return <-results, response
+	}
+
+	t.Run("gzip body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData)))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		result, response := serve(t, req)
+
+		assertNoError(t, result.err)
+		assertEqual(t, string(sourceData), string(result.data))
+		assertEqual(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("over limit with error handler", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData)))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		result, response := serve(t, req, ContentLengthLimit(10))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(result.err, &tooLarge))
+		assertEqual(t, int64(10), tooLarge.Limit)
+		// The caller responds, rather than the error handler.
+		assertEqual(t, http.StatusTeapot, response.Code)
+		assertEqual(t, true, result.hasErrorHandler)
+	})
+
+	t.Run("over limit returning errors", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+
+		result, _ := serve(t, req, ContentLengthLimit(10), ReturnOnError())
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(result.err, &tooLarge))
+		assertEqual(t, false, result.hasErrorHandler)
+	})
+
+	t.Run("unwrapped request", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+
+		data, err := ReadAll(req)
+
+		assertNoError(t, err)
+		assertEqual(t, "data", string(data))
+	})
+
+	t.Run("over total buffer cap", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData)))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		result, response := serve(t, req, MaxTotalBufferBytes(10))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(result.err, &tooLarge))
+		assertEqual(t, int64(10), tooLarge.Limit)
+		assertEqual(t, http.StatusTeapot, response.Code)
+	})
+
+	t.Run("counts towards total buffer cap", func(t *testing.T) {
+		t.Parallel()
+		buffered := make(chan int64, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = ReadAll(r)
+			body, _ := bodyFromRequest(r)
+			buffered <- body.bufferedBytes
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+		RequestBodyHandler(http.HandlerFunc(handler), MaxTotalBufferBytes(100)).ServeHTTP(httptest.NewRecorder(), req)
+
+		assertEqual(t, int64(len(sourceData)), <-buffered)
+	})
+}
@@ -0,0 +1,57 @@
+package requestbody
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// statusRecorder wraps the response writer passed to the handler to track whether the response
+// has been committed, so error handlers can tell whether they can still set a status code.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	// Informational responses don't commit the final status, except for switching protocols.
+	if s.status == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Flush commits the response, and does nothing if the wrapped writer can't be flushed.
+func (s *statusRecorder) Flush() {
+	if err := http.NewResponseController(s.ResponseWriter).Flush(); err == nil && s.status == 0 {
+		s.status = http.StatusOK
+	}
+}
+
+// Hijack takes over the connection when supported by the wrapped writer.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(s.ResponseWriter).Hijack()
+	if err == nil && s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap allows http.ResponseController to access the wrapped writer.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// responseCommitted reports whether the response status has already been sent by the handler,
+// after which an error handler can no longer set the status code.
+func responseCommitted(w http.ResponseWriter) bool {
+	recorder, ok := w.(*statusRecorder)
+	return ok && recorder.status != 0
+}
@@ -0,0 +1,89 @@
+package requestbody
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// headerCountingWriter counts calls to WriteHeader, which are ignored after the first by the recorder.
+type headerCountingWriter struct {
+	*httptest.ResponseRecorder
+	writeHeaderCalls int
+}
+
+func (h *headerCountingWriter) WriteHeader(code int) {
+	h.writeHeaderCalls++
+	h.ResponseRecorder.WriteHeader(code)
+}
+
+func TestStatusRecorder(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name      string
+		write     func(w http.ResponseWriter)
+		committed bool
+		status    int
+	}{
+		{"nothing written", func(w http.ResponseWriter) {}, false, 0},
+		{"header set", func(w http.ResponseWriter) { w.Header().Set("X-Test", "1") }, false, 0},
+		{"informational status", func(w http.ResponseWriter) { w.WriteHeader(http.StatusEarlyHints) }, false, 0},
+		{"status written", func(w http.ResponseWriter) { w.WriteHeader(http.StatusAccepted) }, true, http.StatusAccepted},
+		{"body written", func(w http.ResponseWriter) { _, _ = w.Write([]byte("data")) }, true, http.StatusOK},
+		{"flushed", func(w http.ResponseWriter) { w.(http.Flusher).Flush() }, true, http.StatusOK},
+		{"first status kept", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusCreated)
+			w.WriteHeader(http.StatusBadRequest)
+		}, true, http.StatusCreated},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			recorder := &statusRecorder{ResponseWriter: httptest.NewRecorder()}
+
+			test.write(recorder)
+
+			assertEqual(t, test.committed, responseCommitted(recorder))
+			assertEqual(t, test.status, recorder.status)
+		})
+	}
+
+	t.Run("not a recorder", func(t *testing.T) {
+		t.Parallel()
+		w := httptest.NewRecorder()
+		w.WriteHeader(http.StatusOK)
+
+		assertEqual(t, false, responseCommitted(w))
+	})
+
+	t.Run("error after response committed", func(t *testing.T) {
+		t.Parallel()
+		handler := RequestBodyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+			buf := make([]byte, 10)
+			_, _ = r.Body.Read(buf)
+		}), ContentLengthLimit(5))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("too large body")))
+		req.ContentLength = -1
+		w := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+
+		handler.ServeHTTP(w, req)
+
+		assertEqual(t, http.StatusAccepted, w.Code)
+		assertEqual(t, 1, w.writeHeaderCalls)
+	})
+
+	t.Run("error before response committed", func(t *testing.T) {
+		t.Parallel()
+		handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), ContentLengthLimit(5))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("too large body")))
+		req.ContentLength = -1
+		w := &headerCountingWriter{ResponseRecorder: httptest.NewRecorder()}
+
+		handler.ServeHTTP(w, req)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, w.Code)
+		assertEqual(t, 1, w.writeHeaderCalls)
+	})
+}
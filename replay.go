@@ -0,0 +1,58 @@
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// MakeReplayable buffers the decoded request body, up to maxBuffer bytes, and replaces
+// r.Body with an in-memory copy. It also populates r.GetBody so the body can be obtained
+// again as a fresh reader, for example when replaying the request to an internal service
+// with the standard library client.
+//
+// Because the buffered body has already been decoded, r.ContentLength is updated to the
+// decoded length and the Content-Encoding header is removed.
+//
+// If the decoded body exceeds maxBuffer, a RequestContentTooLargeError is returned.
+// Errors are handled in the same way as when reading from the body directly, so when the
+// middleware is configured with an error handler, the error handler will write the response.
+//
+// The buffer counts towards the MaxTotalBufferBytes limit for the request.
+func MakeReplayable(r *http.Request, maxBuffer int64) error {
+	allowed, limit := maxBuffer, maxBuffer
+	body, wrapped := bodyFromRequest(r)
+	if wrapped {
+		allowed, limit = body.bufferLimit(maxBuffer)
+	}
+	var data []byte
+	fits := true
+	if r.Body != nil {
+		var err error
+		data, fits, err = readAtMost(r.Body, allowed)
+		if err != nil {
+			return err
+		}
+	}
+	if !fits {
+		var err error = &RequestContentTooLargeError{
+			Limit: limit,
+			Read:  int64(len(data)),
+		}
+		if wrapped {
+			err = body.handleError(err)
+		}
+		return err
+	}
+	if wrapped {
+		body.bufferedBytes += int64(len(data))
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r.ContentLength = int64(len(data))
+	r.Header.Del("Content-Encoding")
+	return nil
+}
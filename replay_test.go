@@ -0,0 +1,102 @@
+package requestbody
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestMakeReplayable(t *testing.T) {
+	t.Parallel()
+
+	replayHandler := func(maxBuffer int64) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := MakeReplayable(r, maxBuffer); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			first, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			fresh, err := r.GetBody()
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			second, err := io.ReadAll(fresh)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(first)
+			_, _ = w.Write(second)
+		}
+	}
+
+	t.Run("replay decoded body", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, replayHandler(100))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, string(sourceData)+string(sourceData), string(responseBody))
+	})
+
+	t.Run("over buffer cap", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, replayHandler(10))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("buffers over total cap", func(t *testing.T) {
+		t.Parallel()
+		// Two layers of middleware each buffering the body for replay.
+		doubleReplay := func(w http.ResponseWriter, r *http.Request) {
+			if err := MakeReplayable(r, 100); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			replayHandler(100)(w, r)
+		}
+		ts := setupServer(t, doubleReplay, MaxTotalBufferBytes(60))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("buffers within total cap", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, replayHandler(100), MaxTotalBufferBytes(60))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("unlimited buffer", func(t *testing.T) {
+		t.Parallel()
+		// The maximum buffer mustn't overflow when detecting bodies over the limit.
+		ts := setupServer(t, replayHandler(math.MaxInt64))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData)+string(sourceData), readString(t, response))
+	})
+}
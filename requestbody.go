@@ -1,16 +1,25 @@
 package requestbody
 
 import (
-	"compress/flate"
-	"compress/gzip"
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"maps"
+	"math"
 	"net/http"
+	"os"
+	"reflect"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // RequestBodyHandler is middleware for handling content encoding and content length.
@@ -23,85 +32,189 @@ import (
 // Wrapped handlers can override the default options on a per-request basis using
 // the `SetRequestBodyOption` function to set options on the request context.
 func RequestBodyHandler(h http.Handler, defaults ...Option) http.Handler {
-	defaultOptions := options{
-		handleError:          StatusOnlyRequestBodyErrorHandler,
-		requireContentLength: false,
-		maxContentLength:     10 * 1024 * 1024, // Default to 10MB
-		supportedEncodings: map[string]encoding{
-			"gzip":    {GZipEncodingReader, false},
-			"x-gzip":  {GZipEncodingReader, true}, // Alias for gzip
-			"deflate": {DeflateEncodingReader, false},
-		},
-	}
-	for _, opt := range defaults {
-		opt.apply(&defaultOptions)
-	}
+	defaultOptions := newOptions(defaults)
+	// The semaphore is shared by all requests, rather than held in the per-request options.
+	semaphore := newBodySemaphore(defaultOptions.maxConcurrentBodies)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodOptions {
-			supportedNames := make([]string, 0, len(defaultOptions.supportedEncodings))
-			for name := range defaultOptions.supportedEncodings {
-				if !defaultOptions.supportedEncodings[name].alias {
-					supportedNames = append(supportedNames, name)
+			// Advertise supported encodings in the response headers for OPTIONS requests.
+			advertised := defaultOptions.advertisedEncodings()
+			if defaultOptions.dynamicAdvertise != nil {
+				if dynamic := defaultOptions.dynamicAdvertise(r); dynamic != nil {
+					advertised = dynamic
 				}
 			}
-			sort.Strings(supportedNames)
-			// Advertise supported encodings in the response headers for OPTIONS requests.
-			w.Header().Set("Accept-Encoding", strings.Join(supportedNames, ", "))
+			if accept := r.Header.Get("Accept-Encoding"); defaultOptions.negotiateOptionsAdvertise && accept != "" {
+				advertised = NegotiateEncodings(accept, advertised)
+			}
+			setAcceptEncoding(w, advertised)
+			if defaultOptions.handleOptionsDirectly {
+				if len(defaultOptions.optionsAllowMethods) > 0 {
+					w.Header().Set("Allow", strings.Join(defaultOptions.optionsAllowMethods, ", "))
+				}
+				writeOptionsResponse(w, defaultOptions.optionsResponseBody)
+				return
+			}
+		} else if defaultOptions.advertiseAcceptEncoding {
+			setAcceptEncoding(w, defaultOptions.advertisedEncodings())
 		}
 
+		// Track whether the response is committed for error handlers, while MaxBytesReader
+		// still uses the original writer to close the connection when the limit is exceeded.
+		recorder := &statusRecorder{ResponseWriter: w}
+
 		// Note: we don't immediately error on content length exceeding the limit,
 		// because we want to allow the downstream handler to override the default limits.
 
 		lazyBody := &lazyReader{
 			reader:          r.Body,
 			contentLength:   r.ContentLength,
-			contentEncoding: r.Header.Get("Content-Encoding"),
+			contentEncoding: contentEncodingHeader(r.Header),
+			contentType:     r.Header.Get("Content-Type"),
 			options:         defaultOptions,
 			request:         r,
 			writer:          w,
+			slot:            bodySlot{semaphore: semaphore},
 		}
+		defer lazyBody.slot.release()
+		// Handlers needn't close the body, so return pooled decoders once the handler is done with it.
+		defer lazyBody.releaseDecoders()
+		defer lazyBody.stopReadDeadline()
 
-		r = r.WithContext(context.WithValue(r.Context(), contextKey, &lazyBody.options))
+		r = r.WithContext(context.WithValue(r.Context(), contextKey, lazyBody))
 		r.Body = lazyBody
+		// Callbacks receive the wrapped request, so accessors such as BytesRead work within them.
+		lazyBody.request = r
+
+		if defaultOptions.antiSmuggling {
+			if err := checkSmuggling(r); err != nil {
+				if handler := defaultOptions.errorHandlerFor(err); handler != nil {
+					lazyBody.observeRejected(err)
+					defaultOptions.handleBodyError(handler, recorder, r, err)
+					return
+				}
+				// Fail the first read so the handler sees the error.
+				lazyBody.once.Do(func() {
+					lazyBody.initErr = err
+				})
+			}
+		}
 
 		defer func() {
 			if v := recover(); v != nil {
 				if bodyError, ok := v.(bodyErrorPanic); ok {
-					bodyError.handler(w, r, bodyError.err)
+					defaultOptions.handleBodyError(bodyError.handler, recorder, r, bodyError.err)
+				} else if defaultOptions.recoverPanic != nil && v != http.ErrAbortHandler {
+					defaultOptions.recoverPanic(recorder, r, v)
 				} else {
 					// If it's not a RequestBodyError, re-panic to let it bubble up.
 					panic(v)
 				}
 			}
 		}()
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(recorder, r)
+
+		if lazyBody.options.requireFullConsumption {
+			if drained := lazyBody.drain(); drained > 0 && lazyBody.options.onPartialConsumption != nil {
+				lazyBody.options.onPartialConsumption(r, drained)
+			}
+		}
 	})
 }
 
+// newOptions returns the default options with the given options applied.
+func newOptions(defaults []Option) options {
+	opts := options{
+		handleError:          defaultErrorHandler(),
+		requireContentLength: false,
+		maxContentLength:     10 * 1024 * 1024, // Default to 10MB
+		antiSmuggling:        true,
+		limitStage:           -1,
+		maxEncodingLayers:    3,
+		supportedEncodings: map[string]encoding{
+			"gzip":    {reader: GZipEncodingReader},
+			"x-gzip":  {reader: GZipEncodingReader, alias: true}, // Alias for gzip
+			"deflate": {reader: DeflateEncodingReader},
+			// "identity" means no transformation, so is accepted but not advertised.
+			"identity": {reader: identityEncodingReader, alias: true, identity: true},
+		},
+	}
+	if brotliAvailable {
+		opts.supportedEncodings["br"] = encoding{reader: BrotliEncodingReader}
+	}
+	if zstdAvailable {
+		opts.supportedEncodings["zstd"] = encoding{reader: ZstdEncodingReader}
+	}
+	for _, opt := range defaults {
+		opt.apply(&opts)
+	}
+
+	if opts.budgetStore == nil {
+		opts.budgetStore = NewMemoryBudgetStore()
+	}
+	return opts
+}
+
+func identityEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+// GZipEncodingReader decodes gzip, reusing decoders from a pool. The decoder is returned to the pool
+// when the reader is closed, and it can't be read from after that.
 func GZipEncodingReader(r io.Reader) (io.ReadCloser, error) {
-	return gzip.NewReader(r)
+	return newGzipReader(r)
 }
+
+// DeflateEncodingReader decodes raw deflate, reusing decoders from a pool. The decoder is returned to the pool
+// when the reader is closed, and it can't be read from after that.
 func DeflateEncodingReader(r io.Reader) (io.ReadCloser, error) {
-	return flate.NewReader(r), nil
+	return newFlateReader(r), nil
 }
 
 type EncodingReader func(r io.Reader) (io.ReadCloser, error)
 
+// RequestEncodingReader is an EncodingReader which also receives the request, for codings which need it to
+// construct their reader, such as decrypting a body using a key derived from the request headers.
+type RequestEncodingReader func(r io.Reader, req *http.Request) (io.ReadCloser, error)
+
 // RequestBodyError is an interface for errors that can occur while processing the request body.
 // Possible errors are: BadRequestError, RequestContentTooLargeError,
-// RequestContentLengthRequiredError, and RequestUnsupportedMediaTypeError.
+// RequestContentLengthRequiredError, MalformedContentLengthError, RequestUnsupportedMediaTypeError, RequestTooManyFormFieldsError,
+// RequestTimeoutError, TooManyConcurrentBodiesError, and RequestBudgetExceededError.
 type RequestBodyError interface {
 	Error() string
 	RecommendedStatusCode() int
 }
 
+// AsRequestBodyError finds the first RequestBodyError in the error's chain, including when it has
+// been wrapped using fmt.Errorf with %w, such as to map errors returned when using ReturnOnError to
+// a status code:
+//
+//	if rbe, ok := requestbody.AsRequestBodyError(err); ok {
+//		w.WriteHeader(rbe.RecommendedStatusCode())
+//	}
+func AsRequestBodyError(err error) (RequestBodyError, bool) {
+	var bodyErr RequestBodyError
+	if errors.As(err, &bodyErr) {
+		return bodyErr, true
+	}
+	return nil, false
+}
+
 // BadRequestError is returned when the request body is malformed or cannot be processed.
 // The recommended status code for this error is 400 Bad Request.
+// The cause can be found using errors.Is and errors.As, such as io.ErrUnexpectedEOF for a truncated body.
 //
 // See: https://www.rfc-editor.org/rfc/rfc9110.html#name-400-bad-request
 type BadRequestError struct {
 	Err error
+	// Layer is the 1-based position in the Content-Encoding header of the coding which failed to decode,
+	// counting any transfer-codings decoded using DecodeTransferEncoding after the content-codings,
+	// or zero if the error isn't specific to one layer of the decode chain.
+	Layer int
+	// Coding is the content-coding which failed to decode, when Layer is set.
+	Coding string
 }
 
 func (e *BadRequestError) Error() string {
@@ -110,6 +223,9 @@ func (e *BadRequestError) Error() string {
 func (e *BadRequestError) RecommendedStatusCode() int {
 	return http.StatusBadRequest
 }
+func (e *BadRequestError) Unwrap() error {
+	return e.Err
+}
 
 // RequestContentTooLargeError is returned when the request body exceeds the maximum allowed content length.
 // The recommended status code for this error is 413 Request Entity Too Large.
@@ -117,12 +233,20 @@ func (e *BadRequestError) RecommendedStatusCode() int {
 // See: https://www.rfc-editor.org/rfc/rfc9110.html#name-413-content-too-large
 type RequestContentTooLargeError struct {
 	Limit int64
+	// Read is the number of bytes which had been read when the limit was exceeded.
+	// This is zero when the request was rejected based on the declared Content-Length.
+	Read int64
+	// status overrides the recommended status code when set using the ContentTooLargeStatus option.
+	status int
 }
 
 func (e *RequestContentTooLargeError) Error() string {
 	return fmt.Sprintf("Content Too Large: greater than %d bytes", e.Limit)
 }
 func (e *RequestContentTooLargeError) RecommendedStatusCode() int {
+	if e.status != 0 {
+		return e.status
+	}
 	return http.StatusRequestEntityTooLarge
 }
 
@@ -132,25 +256,61 @@ func (e *RequestContentTooLargeError) RecommendedStatusCode() int {
 //
 // See: https://www.rfc-editor.org/rfc/rfc9110.html#name-411-length-required
 type RequestContentLengthRequiredError struct {
+	// status overrides the recommended status code when set using the LengthRequiredStatus option.
+	status int
 }
 
 func (e *RequestContentLengthRequiredError) Error() string {
 	return "Content Length Required"
 }
 func (e *RequestContentLengthRequiredError) RecommendedStatusCode() int {
+	if e.status != 0 {
+		return e.status
+	}
 	return http.StatusLengthRequired
 }
 
+// MalformedContentLengthError is returned when a Content-Length is required but the request's
+// Content-Length header is present and can't be parsed, when enabled using RejectMalformedContentLength.
+// The recommended status code for this error is 400 Bad Request.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9110.html#name-content-length
+type MalformedContentLengthError struct {
+	// Value is the unparseable value of the Content-Length header.
+	Value string
+}
+
+func (e *MalformedContentLengthError) Error() string {
+	return fmt.Sprintf("Bad Request: malformed Content-Length %q", e.Value)
+}
+func (e *MalformedContentLengthError) RecommendedStatusCode() int {
+	return http.StatusBadRequest
+}
+
 // RequestUnsupportedMediaTypeError is returned when the request's Content-Encoding
 // header contains an encoding that is not supported by the server.
 // The recommended status code for this error is 415 Unsupported Media Type.
 //
 // See: https://www.rfc-editor.org/rfc/rfc9110.html#name-415-unsupported-media-type
+//
+// The error is also returned when the body is rejected because of its Content-Type,
+// in which case ContentType is set rather than Encoding.
 type RequestUnsupportedMediaTypeError struct {
 	Encoding string
+	// Supported lists the encodings advertised by the server.
+	Supported []string
+	// ContentType is the declared media type when rejected because of the Content-Type header.
+	ContentType string
+	// Header is the name of the request header which was rejected, such as "Content-Encoding" or "Content-Type".
+	Header string
+	// Value is the rejected value from the header, such as the unsupported content-coding.
+	Value string
 }
 
 func (e *RequestUnsupportedMediaTypeError) Error() string {
+	if e.Encoding == "" && e.ContentType != "" {
+		return "Unsupported Media Type: " + e.ContentType
+	}
 	return "Unsupported Media Type: " + e.Encoding
 }
 func (e *RequestUnsupportedMediaTypeError) RecommendedStatusCode() int {
@@ -162,229 +322,1957 @@ type contextType struct{}
 var contextKey = contextType{}
 
 // Option is a functional option for configuring the request body handler.
-// Valid options are ContentLengthLimit, RequireContentLength, SupportEncoding, DisableEncoding,
-// HandleRequestBodyError, and ReturnOnError.
+// Valid options include ContentLengthLimit, RequireContentLength, SupportEncoding, DisableEncoding,
+// DecodeByteBudget, HandleRequestBodyError, and ReturnOnError.
 type Option interface {
 	apply(*options)
 }
 
+// Options is an ordered set of options which can itself be used as an Option.
+// When options conflict, later options take precedence over earlier ones, which allows layering
+// configuration such as route specific options over global defaults:
+//
+//	global := requestbody.Options{requestbody.ContentLengthLimit(1024 * 1024)}
+//	upload := global.With(requestbody.ContentLengthLimit(100 * 1024 * 1024))
+//	handler := requestbody.RequestBodyHandler(mux, upload)
+type Options []Option
+
+// With returns a new Options with the given options applied after the existing options,
+// so they take precedence. The receiver isn't modified.
+func (o Options) With(opts ...Option) Options {
+	return append(slices.Clip(o), opts...)
+}
+
+func (o Options) apply(opts *options) {
+	for _, opt := range o {
+		if opt != nil {
+			opt.apply(opts)
+		}
+	}
+}
+
 type options struct {
-	maxContentLength     int64
-	requireContentLength bool
-	supportedEncodings   map[string]encoding
-	handleError          RequestBodyErrorHandler
+	maxContentLength            int64
+	requireContentLength        bool
+	supportedEncodings          map[string]encoding
+	handleError                 RequestBodyErrorHandler
+	kindErrorHandlers           []kindErrorHandler
+	decodeByteBudget            int64
+	decoderReadChunk            int
+	declaredLengthTolerance     float64
+	enforceContentLengthMatch   bool
+	strictEncodingParsing       bool
+	trustContentLength          bool
+	rejectMalformedLength       bool
+	rejectChunked               bool
+	limitHeader                 string
+	validateLimitHeader         func(r *http.Request) bool
+	rawDigest                   func() hash.Hash
+	observer                    Observer
+	inspectGzipExtra            func(extra []byte) error
+	lengthRequiredStatus        int
+	contentTooLargeStatus       int
+	maxFinalRatio               float64
+	maxCompressionRatio         float64
+	maxTotalBufferBytes         int64
+	rejectMislabeledContentType bool
+	multiFrame                  map[string]bool
+	requireFullConsumption      bool
+	prefixEncodings             []prefixEncoding
+	maxEncodingTokens           int
+	lenientDecode               bool
+	limitStage                  int
+	strictAdvertisedEncodings   bool
+	exactContentType            *exactContentType
+	maxFormFields               int
+	decompressedSizeLimit       int64
+	deflateOutputLimit          int64
+	negotiateOptionsAdvertise   bool
+	maxEncodingLayers           int
+	onDeprecatedEncoding        func(r *http.Request, name string)
+	onBodyComplete              func(r *http.Request, bytesRead int64, encoding string)
+	minCompressionRatio         float64
+	decodeTransferEncoding      bool
+	allowedContentTypes         []string
+	initTimeout                 time.Duration
+	readTimeout                 time.Duration
+	rewindBuffer                int64
+	encodingLimits              map[string]int64
+	maxConcurrentBodies         int
+	maxConcurrentBodiesWait     time.Duration
+	clientBudget                int64
+	clientBudgetWindow          time.Duration
+	clientBudgetKey             func(r *http.Request) string
+	budgetStore                 BudgetStore
+	budgetStoreFailOpen         bool
+	rejectControlCharacters     bool
+	onPartialConsumption        func(r *http.Request, drained int64)
+	onLateOptions               func(r *http.Request)
+	passthroughUnknownEncoding  bool
+	// antiSmuggling is only read from the middleware defaults as it's checked before the
+	// wrapped handler is called.
+	antiSmuggling bool
+	// handleOptionsDirectly is only read from the middleware defaults as OPTIONS responses
+	// are written before the wrapped handler is called.
+	handleOptionsDirectly bool
+	dynamicAdvertise      func(r *http.Request) []string
+	optionsResponseBody   []byte
+	optionsAllowMethods   []string
+	recoverPanic          func(w http.ResponseWriter, r *http.Request, v any)
+	// advertiseAcceptEncoding is only read from the middleware defaults as the header is set
+	// before the wrapped handler is called.
+	advertiseAcceptEncoding bool
+}
+
+// advertisedEncodings returns the sorted names of the supported encodings, excluding aliases and
+// encodings excluded from the build.
+func (o *options) advertisedEncodings() []string {
+	supportedNames := make([]string, 0, len(o.supportedEncodings))
+	for name, enc := range o.supportedEncodings {
+		if !enc.alias && !enc.unavailable {
+			supportedNames = append(supportedNames, name)
+		}
+	}
+	sort.Strings(supportedNames)
+	return supportedNames
+}
+
+// contentEncodingHeader combines multiple Content-Encoding header fields into a single list, as the RFC
+// defines them to be equivalent, so "gzip" followed by "deflate" is the same as "gzip, deflate".
+// A whitespace-only field is treated the same as an empty field, meaning no encoding.
+// https://www.rfc-editor.org/rfc/rfc9110.html#name-field-order
+func contentEncodingHeader(header http.Header) string {
+	var values []string
+	for _, value := range header.Values("Content-Encoding") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// setAcceptEncoding sets the Accept-Encoding response header to the advertised encodings.
+func setAcceptEncoding(w http.ResponseWriter, advertised []string) {
+	w.Header().Set("Accept-Encoding", strings.Join(advertised, ", "))
+}
+
+// handleBodyError calls the error handler, first advertising the supported encodings when a 415
+// response is caused by the Content-Encoding and AdvertiseAcceptEncoding is enabled.
+func (o *options) handleBodyError(handler RequestBodyErrorHandler, w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+	if unsupported, ok := err.(*RequestUnsupportedMediaTypeError); ok && o.advertiseAcceptEncoding && unsupported.Encoding != "" {
+		// Supported reflects any per-request overrides of the encodings.
+		setAcceptEncoding(w, unsupported.Supported)
+	}
+	handler(w, r, err)
+}
+
+// setEncoding adds, replaces or, when nil, removes a supported encoding. The map is copied
+// so per-request overrides don't modify the middleware defaults shared by all requests.
+func (o *options) setEncoding(name string, enc *encoding) {
+	name = strings.ToLower(name) // Content-codings are case-insensitive.
+	supportedEncodings := maps.Clone(o.supportedEncodings)
+	if supportedEncodings == nil {
+		supportedEncodings = make(map[string]encoding)
+	}
+	if enc == nil {
+		delete(supportedEncodings, name)
+	} else {
+		supportedEncodings[name] = *enc
+	}
+	o.supportedEncodings = supportedEncodings
+}
+
+// resolveEncoding finds the encoding for a content-coding token, preferring exact matches
+// over prefix matches and longer prefixes over shorter ones.
+func (o *options) resolveEncoding(token string) (encoding, bool) {
+	if encoder, supported := o.supportedEncodings[token]; supported {
+		return encoder, true
+	}
+	var match *prefixEncoding
+	for i, candidate := range o.prefixEncodings {
+		if strings.HasPrefix(token, candidate.prefix) && (match == nil || len(candidate.prefix) > len(match.prefix)) {
+			match = &o.prefixEncodings[i]
+		}
+	}
+	if match == nil {
+		return encoding{}, false
+	}
+	return match.encoding, true
+}
+
+// decodableEncoding resolves the lowercase coding, rejecting aliases when using StrictAdvertisedEncodings.
+func (o *options) decodableEncoding(name string) (encoding, bool) {
+	encoder, supported := o.resolveEncoding(name)
+	if supported && encoder.alias && o.strictAdvertisedEncodings {
+		return encoding{}, false // Reject codings which aren't advertised in strict mode.
+	}
+	return encoder, supported
+}
+
+// isDeprecated reports whether the content-coding is a registered alias, such as "x-gzip",
+// or one of the obsolete "compress" codings.
+func (o *options) isDeprecated(name string) bool {
+	if encoder, ok := o.supportedEncodings[name]; ok && encoder.alias && !encoder.identity {
+		return true
+	}
+	return name == "compress" || name == "x-compress"
+}
+
+type prefixEncoding struct {
+	prefix string
+	encoding
 }
 
 type encoding struct {
 	reader EncodingReader
+	// requestReader is used in place of reader when the encoding was added using SupportEncodingFunc.
+	requestReader RequestEncodingReader
 	// alias skips the encoding being advertised in the Accept-Encoding header.
 	alias bool
+	// nonChainable rejects the encoding when combined with any other encoding.
+	nonChainable bool
+	// identity is accepted without adding a decoder to the chain.
+	identity bool
+	// unavailable skips advertising an encoding whose decoder was excluded from the build.
+	unavailable bool
 }
 
 // ContentLengthLimit sets the maximum content length for the request body.
 // If the request body exceeds this limit, a RequestContentTooLargeError will be returned.
 // The default limit is 10MB (10 * 1024 * 1024 bytes).
 // If you want to disable the limit, use ContentLengthLimit(-1).
+// The limit applies to the decoded body, unless DecompressedSizeLimit is set in which case it
+// applies to the raw body.
 func ContentLengthLimit(maxContentLength int64) Option {
 	return optionFunc{
 		f: func(opts *options) {
 			opts.maxContentLength = maxContentLength
 		},
+		appliesAfterInit: true,
 	}
 }
 
-type RequestBodyErrorHandler func(w http.ResponseWriter, r *http.Request, err RequestBodyError)
+// LimitFromHeader sets the content length limit for a request from the value of a header, such as one
+// added by an upstream gateway to raise the limit for trusted callers. The header is only used when validate
+// returns true for the request, and must be a non-negative decimal number of bytes. Otherwise, such as for an
+// untrusted caller or an invalid value, the configured limit is used.
+// The limit is taken from the header when the body is first read, so it replaces any limit set before then,
+// including per-request overrides using SetRequestBodyOption.
+// LimitFromHeader panics if the header name is empty or validate is nil.
+func LimitFromHeader(headerName string, validate func(r *http.Request) bool) Option {
+	if headerName == "" || validate == nil {
+		panic("requestbody: LimitFromHeader requires a header name and validate function")
+	}
+	return optionFunc{
+		f: func(opts *options) {
+			opts.limitHeader = headerName
+			opts.validateLimitHeader = validate
+		},
+	}
+}
 
-// StatusOnlyRequestBodyErrorHandler is the default error handler that only writes the status code
-// recommended by the RequestBodyError interface.
-func StatusOnlyRequestBodyErrorHandler(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
-	w.WriteHeader(err.RecommendedStatusCode())
+// headerLimit returns the limit set by the LimitFromHeader header, if present, valid and trusted.
+func (r *lazyReader) headerLimit() (int64, bool) {
+	if r.options.limitHeader == "" {
+		return 0, false
+	}
+	value := strings.TrimSpace(r.request.Header.Get(r.options.limitHeader))
+	if value == "" || !r.options.validateLimitHeader(r.request) {
+		return 0, false
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit < 0 {
+		return 0, false
+	}
+	return limit, true
 }
 
-// HandleRequestBodyError will halt request processing using a panic which will be recovered by the middleware
-// and use the handler to write a response.
-// Passing nil for the handler will disable this behaviour and return the error to the reader of the body which
-// is the same as using the ReturnOnError option.
-// If not specified, the StatusOnlyRequestBodyErrorHandler will be used.
-func HandleRequestBodyError(handler RequestBodyErrorHandler) Option {
+// TrustContentLength relies on the declared Content-Length to enforce the content length limit for unencoded
+// bodies, rather than counting every byte as it's read. Bodies declaring a length over the limit are still
+// rejected before reading, but the body isn't limited while reading, so this should only be enabled when the
+// Content-Length is known to match the body, such as when served by net/http or behind a trusted proxy.
+// Bodies of unknown length and encoded bodies are always limited while reading, as their decoded size isn't
+// bounded by the declared length.
+// This is disabled by default.
+func TrustContentLength(enable bool) Option {
 	return optionFunc{
 		f: func(opts *options) {
-			opts.handleError = handler
+			opts.trustContentLength = enable
 		},
 	}
 }
 
-// ReturnOnError will not modify the response, leaving it up to the reader of the
-// body to handle RequestBodyError errors.
-func ReturnOnError() Option {
+// DecodeByteBudget limits the total number of bytes fed into decoders across the whole
+// Content-Encoding chain. For a body encoded as "deflate, gzip" this counts the raw bytes read
+// by the gzip decoder plus the intermediate bytes read by the deflate decoder.
+// If the budget is exceeded, a RequestContentTooLargeError will be returned.
+// The budget is disabled by default, or when set to zero or less.
+func DecodeByteBudget(n int64) Option {
 	return optionFunc{
 		f: func(opts *options) {
-			opts.handleError = nil
+			opts.decodeByteBudget = n
 		},
 	}
 }
 
-// RequireContentLength will require the request to have a Content-Length header
-// set to a non-negative value, if set to true.
-func RequireContentLength(require bool) Option {
+// DecoderReadChunk limits the number of bytes each decoder requests from its input in a single read,
+// which bounds the transient memory used to read compressed data, such as for the built-in gzip and
+// deflate readers. Smaller chunks mean more reads, so this trades throughput for memory.
+// This is disabled by default, or when set to zero or less.
+func DecoderReadChunk(n int) Option {
 	return optionFunc{
 		f: func(opts *options) {
-			opts.requireContentLength = require
+			opts.decoderReadChunk = n
 		},
 	}
 }
 
-// SupportEncoding adds a new encoding to the list of supported encodings.
-// If the encoding already exists, it will be replaced.
-func SupportEncoding(name string, reader EncodingReader) Option {
+// InspectGzipExtra registers a callback which is invoked with the contents of the FEXTRA field
+// once the gzip header has been parsed. The extra field is nil if the header doesn't have one.
+// If the callback returns an error, a BadRequestError wrapping it will be returned.
+// This only applies to encodings whose reader is GZipEncodingReader, such as the default "gzip" encoding,
+// or which return a *gzip.Reader.
+func InspectGzipExtra(inspect func(extra []byte) error) Option {
 	return optionFunc{
 		f: func(opts *options) {
-			opts.supportedEncodings[name] = encoding{
-				reader: reader,
-				alias:  false,
-			}
+			opts.inspectGzipExtra = inspect
 		},
 	}
 }
 
-// DisableEncoding removes the specified encoding from the list of supported encodings.
-// If the encoding is not supported, it will have no effect.
-func DisableEncoding(name string) Option {
+// MaxFinalRatio limits the ratio of decoded bytes to raw bytes for encoded bodies, evaluated
+// once the end of the body has been reached. If the ratio is exceeded, a RequestContentTooLargeError
+// will be returned in place of io.EOF. This doesn't stop a compression bomb from being decoded,
+// but catches it after the fact for logging and alerting without any per-read overhead.
+// The check is disabled by default, or when set to zero or less.
+func MaxFinalRatio(ratio float64) Option {
 	return optionFunc{
 		f: func(opts *options) {
-			if opts.supportedEncodings == nil {
-				return // No encodings to disable.
-			}
-			delete(opts.supportedEncodings, name)
+			opts.maxFinalRatio = ratio
 		},
 	}
 }
 
-// SetRequestBodyOption sets options for the request body handler on the request context.
-// These options will override the default options set in the RequestBodyHandler middleware.
-// This allows handlers to customize the behaviour of the request body processing
-// on a per-request basis.
-func SetRequestBodyOption(r *http.Request, opts ...Option) {
-	if r == nil {
-		return
+// compressionRatioMinInput is the raw input MaxCompressionRatio measures the ratio against until more has been
+// read, so the first bytes of a body which compresses well aren't rejected before the ratio has settled.
+const compressionRatioMinInput = 4096
+
+// MaxCompressionRatio limits the ratio of decoded bytes to raw bytes for encoded bodies while reading, stopping
+// a compression bomb as it's decoded even when the decoded body fits within the content length limit. Until
+// 4KB of raw input has been read, the ratio is measured against 4KB, so small bodies which compress well
+// aren't rejected. If the ratio is exceeded, a RequestContentTooLargeError will be returned.
+// Unlike MaxFinalRatio, the ratio is checked on each read.
+// The check is disabled by default, or when set to zero or less.
+func MaxCompressionRatio(ratio float64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxCompressionRatio = ratio
+		},
 	}
-	if ctx := r.Context(); ctx != nil {
-		if existingOpts, ok := ctx.Value(contextKey).(*options); ok {
-			for _, opt := range opts {
-				opt.apply(existingOpts)
-			}
-		}
+}
+
+// MinCompressionRatio requires the ratio of decoded bytes to raw bytes for encoded bodies to be at least
+// the ratio, evaluated once the end of the body has been reached. If the body compressed less, such as a
+// store-only gzip stream sent to bypass size accounting, a BadRequestError will be returned in place of io.EOF.
+// The check is disabled by default, or when set to zero or less.
+func MinCompressionRatio(ratio float64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.minCompressionRatio = ratio
+		},
 	}
 }
 
-type optionFunc struct {
-	f func(*options)
+// DeclaredVsActualTolerance requires the raw bytes read to be within the ratio of the declared Content-Length,
+// evaluated once the end of the body has been reached. For example, a ratio of 0.1 rejects bodies which end
+// more than 10% short of the declared length, such as a client declaring a large length to reserve capacity.
+// If the body is too short, a BadRequestError will be returned in place of io.EOF.
+// Only bodies with a known length are checked.
+// The check is disabled by default, or when set to zero or less.
+func DeclaredVsActualTolerance(ratio float64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.declaredLengthTolerance = ratio
+		},
+	}
 }
 
-func (o optionFunc) apply(opts *options) {
-	o.f(opts)
+// EnforceContentLengthMatch requires the raw bytes read from a body without any content-codings to match its
+// declared Content-Length exactly, evaluated once the end of the body has been reached. net/http already
+// enforces this for bodies read from the connection, but not for requests whose body has been replaced, such
+// as by a proxy or an earlier middleware. If fewer or more bytes were read, a BadRequestError will be returned
+// in place of io.EOF. Bodies with an unknown length, or which are decoded, aren't checked.
+// This is disabled by default.
+func EnforceContentLengthMatch(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.enforceContentLengthMatch = enable
+		},
+	}
 }
 
-type lazyReader struct {
-	once            sync.Once
-	contentLength   int64
-	reader          io.ReadCloser
-	contentEncoding string
-	initErr         error
-	options         options
-	request         *http.Request
-	writer          http.ResponseWriter
+// MaxTotalBufferBytes limits the combined size of all in-memory buffers held for a single request
+// by buffering features: MakeReplayable, ReadAll, ReadForm, ReadGRPCWebMessage, EachLine and Rewindable.
+// If a buffer would take the total over the limit, a RequestContentTooLargeError will be returned.
+// The limit is disabled by default, or when set to zero or less.
+func MaxTotalBufferBytes(n int64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxTotalBufferBytes = n
+		},
+	}
 }
 
-func (r *lazyReader) Read(p []byte) (n int, err error) {
-	r.init()
+// RejectMislabeledContentType sniffs the first 512 decoded bytes of the body using
+// http.DetectContentType and returns a RequestUnsupportedMediaTypeError if the result grossly
+// mismatches the declared Content-Type, such as a PNG image declared as application/json.
+// The check is lenient where sniffing can't distinguish types: textual and unrecognised content,
+// along with any content declared as application/octet-stream, is always accepted.
+func RejectMislabeledContentType(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rejectMislabeledContentType = enable
+		},
+	}
+}
 
-	if r.initErr != nil {
-		return 0, handleError(r.options.handleError, r.initErr)
+// RequireFullConsumption will drain any remainder of the body which the handler didn't read,
+// up to the content length limit, once the handler returns. This catches handler bugs where bodies
+// are only partially read, which prevents the connection from being reused.
+// Use OnPartialConsumption to be notified when this happens.
+func RequireFullConsumption(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.requireFullConsumption = enable
+		},
 	}
+}
 
-	n, err = r.reader.Read(p)
-	if err != nil && err != io.EOF {
-		if mbe, ok := err.(*http.MaxBytesError); ok {
-			err = &RequestContentTooLargeError{
-				Limit: mbe.Limit,
-			}
-		} else {
-			// Wrap other errors in a BadRequestError as we failed while reading the body.
-			err = &BadRequestError{
-				Err: err,
-			}
-		}
+// OnDeprecatedEncoding registers a callback which is invoked for each deprecated content-coding used
+// by a request, such as the "x-gzip" alias or "compress", when the coding is resolved before the body is
+// first read. This can be used to record metrics to track clients migrating to the standard codings.
+func OnDeprecatedEncoding(callback func(r *http.Request, name string)) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.onDeprecatedEncoding = callback
+		},
 	}
-	if err != nil {
-		return n, handleError(r.options.handleError, err)
+}
+
+// OnBodyComplete registers a callback which is invoked once when the body has been read to the end without
+// error, along with the number of decoded bytes read and the Content-Encoding header of the request.
+// The callback is also invoked when reading a zero-length body, but not when an error stops reading or
+// when LenientDecode ends the body early. This can be used to log or record metrics about consumed bodies,
+// including how the body was read using RequestBodyStats.
+func OnBodyComplete(callback func(r *http.Request, bytesRead int64, encoding string)) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.onBodyComplete = callback
+		},
 	}
-	return n, err
 }
 
-func (r *lazyReader) init() {
-	r.once.Do(func() {
-		if r.contentLength == 0 {
-			return // If the content length is zero, we don't need to process the body.
-		}
+// OnPartialConsumption registers a callback which is invoked when RequireFullConsumption drains
+// a body which wasn't fully read by the handler, along with the number of decoded bytes drained.
+// This can be used to log or record metrics about the handler.
+func OnPartialConsumption(callback func(r *http.Request, drained int64)) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.onPartialConsumption = callback
+		},
+	}
+}
 
-		// Fail if content length not provided but is required.
-		if r.contentLength < 0 && r.options.requireContentLength {
-			r.initErr = &RequestContentLengthRequiredError{}
-			return
-		}
+// OnLateOptions registers a callback which is invoked when SetRequestBodyOption is called after the body has
+// been prepared for reading, such as when an earlier middleware has already read from the body, with options
+// other than ContentLengthLimit which may no longer take effect. This can be used to log the misconfiguration
+// in production, or to panic in tests and during development so it's caught before being deployed.
+func OnLateOptions(callback func(r *http.Request)) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.onLateOptions = callback
+		},
+	}
+}
 
-		// Fail fast if content length exceeds the maximum allowed limit.
-		if r.options.maxContentLength > -1 && r.contentLength > r.options.maxContentLength {
-			r.initErr = &RequestContentTooLargeError{
-				Limit: r.options.maxContentLength,
-			}
-			return
-		}
-		var encodings []EncodingReader
-		if r.contentEncoding != "" {
-			for _, encoding := range strings.Split(r.contentEncoding, ",") {
-				trimmed := strings.TrimSpace(encoding)
-				if encoder, supported := r.options.supportedEncodings[trimmed]; supported {
-					encodings = append(encodings, encoder.reader)
-				} else {
-					// If the encoding is not supported, return 415 Unsupported Media Type.
-					// https://www.rfc-editor.org/rfc/rfc9110.html#name-415-unsupported-media-type
-					r.initErr = &RequestUnsupportedMediaTypeError{
-						Encoding: trimmed,
-					}
-					return
-				}
-			}
-		}
+// LenientDecode changes how errors part way through decoding an encoded body are handled, such as
+// a truncated gzip stream. When enabled, the bytes successfully decoded so far are delivered to the
+// handler followed by io.EOF, and the error is recorded and can be retrieved using DecodeError.
+//
+// This trades data integrity for availability, so should only be used where partial data is better
+// than none, such as log ingestion. Handlers can't tell a complete body from a partial one without
+// checking DecodeError. Errors from limits and the initial headers of the encoding still fail the request.
+func LenientDecode(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.lenientDecode = enable
+		},
+	}
+}
 
-		reader := r.reader
-		slices.Reverse(encodings) // Reverse the order to apply the last encoding first.
-		// Unwrap each encoding reader in the order they were provided.
-		for _, encoding := range encodings {
-			// Apply each encoding reader to the reader.
-			wrappedReader, err := encoding(reader)
-			if err != nil {
-				r.initErr = &BadRequestError{
-					Err: fmt.Errorf("failed to create encoding reader for %s: %w", r.contentEncoding, err),
-				}
-				return
-			}
-			reader = wrappedReader
-		}
-		if r.options.maxContentLength > 0 {
-			// Limit the reader to the specified max content length.
-			reader = http.MaxBytesReader(r.writer, reader, r.options.maxContentLength)
-		}
-		r.reader = reader
-	})
+// DecompressedSizeLimit limits the size of the decoded body separately from its raw size, to protect
+// against small compressed bodies which decode to huge payloads. When set, ContentLengthLimit limits the raw
+// body read from the wire, unless LimitStage selects another stage, and a decoded body exceeding the
+// decompressed limit returns a RequestContentTooLargeError for the decompressed limit.
+// This is disabled by default, or when set to zero or less, in which case ContentLengthLimit limits the
+// decoded body.
+func DecompressedSizeLimit(n int64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.decompressedSizeLimit = n
+		},
+	}
+}
+
+// DeflateOutputLimit limits the output of each deflate decoder in the chain, even when the content length
+// limit is disabled, as a raw deflate stream has no length framing and could otherwise be read forever.
+// If the output exceeds the limit, a RequestContentTooLargeError will be returned.
+// This is disabled by default, or when set to zero or less, leaving deflate output bounded only
+// by the content length limit.
+func DeflateOutputLimit(n int64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.deflateOutputLimit = n
+		},
+	}
+}
+
+// EncodingLimit sets the maximum decoded size for bodies using the named content-coding, overriding the
+// content length limit for the decoded body, as codings have different expansion risks. The limit for
+// "identity" applies to bodies without a Content-Encoding. If multiple codings with limits are stacked, the
+// most restrictive limit applies. The content length limit still applies to a declared Content-Length.
+// If the decoded body exceeds the limit, a RequestContentTooLargeError will be returned.
+// Setting the limit to zero or less removes the override for the coding.
+func EncodingLimit(name string, maxDecompressed int64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			// Copy so per-request overrides don't modify the middleware defaults.
+			encodingLimits := maps.Clone(opts.encodingLimits)
+			if encodingLimits == nil {
+				encodingLimits = make(map[string]int64)
+			}
+			if maxDecompressed > 0 {
+				encodingLimits[strings.ToLower(name)] = maxDecompressed
+			} else {
+				delete(encodingLimits, strings.ToLower(name))
+			}
+			opts.encodingLimits = encodingLimits
+		},
+	}
+}
+
+// encodingLimitFor returns the most restrictive EncodingLimit for the codings,
+// or zero if none of the codings have a limit.
+func (o *options) encodingLimitFor(encodings []namedEncoding) int64 {
+	if len(encodings) == 0 {
+		return o.encodingLimits["identity"]
+	}
+	var limit int64
+	for _, encoding := range encodings {
+		if encodingLimit, ok := o.encodingLimits[encoding.name]; ok && (limit == 0 || encodingLimit < limit) {
+			limit = encodingLimit
+		}
+	}
+	return limit
+}
+
+// LimitStage selects the point in the decode chain where the content length limit is applied
+// to the bytes read. Stage 0 limits the raw body, and each following stage limits the output of
+// one more decoder, starting with the outermost encoding. For a body encoded as "deflate, gzip",
+// stage 1 limits the deflate encoded output of the gzip decoder. Stages beyond the end of the chain,
+// or -1 (the default), limit the final decoded output.
+//
+// When the limit is applied to an intermediate stage, changes to the limit made after reading
+// began are not applied. LimitStage panics if the stage is less than -1.
+func LimitStage(stage int) Option {
+	if stage < -1 {
+		panic(fmt.Sprintf("requestbody: invalid limit stage %d", stage))
+	}
+	return optionFunc{
+		f: func(opts *options) {
+			opts.limitStage = stage
+		},
+	}
+}
+
+type RequestBodyErrorHandler func(w http.ResponseWriter, r *http.Request, err RequestBodyError)
+
+// StatusOnlyRequestBodyErrorHandler is the default error handler that only writes the status code
+// recommended by the RequestBodyError interface. Nothing is written if the handler has already
+// sent the response status.
+func StatusOnlyRequestBodyErrorHandler(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+	if responseCommitted(w) {
+		return // The handler already sent a status, so it can't be changed.
+	}
+	w.WriteHeader(err.RecommendedStatusCode())
+}
+
+// HandleRequestBodyError will halt request processing using a panic which will be recovered by the middleware
+// and use the handler to write a response.
+// Passing nil for the handler will disable this behaviour and return the error to the reader of the body which
+// is the same as using the ReturnOnError option.
+// If not specified, the StatusOnlyRequestBodyErrorHandler will be used, unless changed using SetDefaultErrorHandler.
+func HandleRequestBodyError(handler RequestBodyErrorHandler) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.handleError = handler
+		},
+	}
+}
+
+// HandleErrorKind registers an error handler for one kind of RequestBodyError, such as writing problem details
+// for BadRequestError while other errors only set the status:
+//
+//	requestbody.HandleErrorKind(reflect.TypeOf(&requestbody.BadRequestError{}), problemHandler)
+//
+// The kind is usually a pointer to one of the error types, but may be an interface type, in which case the
+// handler applies to any error implementing it. When an error occurs, a handler registered for its exact type
+// is used first, then the first registered interface it implements, falling back to the HandleRequestBodyError
+// handler. Kinds with a handler also halt request processing when using ReturnOnError, so only other errors are
+// returned from reads. Passing nil for the handler removes the registration for the kind.
+// HandleErrorKind panics if the kind doesn't implement RequestBodyError.
+func HandleErrorKind(kind reflect.Type, handler RequestBodyErrorHandler) Option {
+	if kind == nil || !kind.Implements(reflect.TypeFor[RequestBodyError]()) {
+		panic(fmt.Sprintf("requestbody: %v doesn't implement RequestBodyError", kind))
+	}
+	return optionFunc{
+		f: func(opts *options) {
+			// Copy so per-request overrides don't modify the middleware defaults.
+			handlers := slices.DeleteFunc(slices.Clone(opts.kindErrorHandlers), func(h kindErrorHandler) bool {
+				return h.kind == kind
+			})
+			if handler != nil {
+				handlers = append(handlers, kindErrorHandler{kind: kind, handler: handler})
+			}
+			opts.kindErrorHandlers = handlers
+		},
+	}
+}
+
+// HandleErrorOf is a type-safe variant of HandleErrorKind, registering a handler for errors of type E:
+//
+//	requestbody.HandleErrorOf(func(w http.ResponseWriter, r *http.Request, err *requestbody.BadRequestError) {
+//		// Write problem details for err.
+//	})
+func HandleErrorOf[E RequestBodyError](handler func(w http.ResponseWriter, r *http.Request, err E)) Option {
+	if handler == nil {
+		return HandleErrorKind(reflect.TypeFor[E](), nil)
+	}
+	return HandleErrorKind(reflect.TypeFor[E](), func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		handler(w, r, err.(E))
+	})
+}
+
+// kindErrorHandler is an error handler registered for a kind of error using HandleErrorKind.
+type kindErrorHandler struct {
+	kind    reflect.Type
+	handler RequestBodyErrorHandler
+}
+
+// errorHandlerFor returns the most specific error handler for the error, or nil if the error should be
+// returned from the read.
+func (o *options) errorHandlerFor(err error) RequestBodyErrorHandler {
+	if kind := reflect.TypeOf(err); kind != nil && len(o.kindErrorHandlers) > 0 {
+		var implemented RequestBodyErrorHandler
+		for _, h := range o.kindErrorHandlers {
+			if h.kind == kind {
+				return h.handler
+			}
+			if implemented == nil && h.kind.Kind() == reflect.Interface && kind.Implements(h.kind) {
+				implemented = h.handler
+			}
+		}
+		if implemented != nil {
+			return implemented
+		}
+	}
+	return o.handleError
+}
+
+var (
+	defaultErrorHandlerMu    sync.RWMutex
+	defaultErrorHandlerValue RequestBodyErrorHandler = StatusOnlyRequestBodyErrorHandler
+)
+
+// SetDefaultErrorHandler sets the error handler used by handlers created by RequestBodyHandler afterwards,
+// unless overridden using the HandleRequestBodyError or ReturnOnError options. Existing handlers aren't
+// affected. Passing nil restores the StatusOnlyRequestBodyErrorHandler default.
+// It's safe to call concurrently, but is intended to be called once during program initialization.
+func SetDefaultErrorHandler(h RequestBodyErrorHandler) {
+	if h == nil {
+		h = StatusOnlyRequestBodyErrorHandler
+	}
+	defaultErrorHandlerMu.Lock()
+	defer defaultErrorHandlerMu.Unlock()
+	defaultErrorHandlerValue = h
+}
+
+func defaultErrorHandler() RequestBodyErrorHandler {
+	defaultErrorHandlerMu.RLock()
+	defer defaultErrorHandlerMu.RUnlock()
+	return defaultErrorHandlerValue
+}
+
+// RecoverAllPanics recovers any other panic from the wrapped handler, in addition to the panics
+// used for RequestBodyError handling, and passes the recovered value to the handler so it can write
+// a response such as 500 Internal Server Error. Panics with http.ErrAbortHandler are always re-raised.
+// By default, other panics are re-raised to let them bubble up.
+// This option only has an effect when passed to RequestBodyHandler.
+func RecoverAllPanics(handler func(w http.ResponseWriter, r *http.Request, v any)) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.recoverPanic = handler
+		},
+	}
+}
+
+// ReturnOnError will not modify the response, leaving it up to the reader of the
+// body to handle RequestBodyError errors.
+func ReturnOnError() Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.handleError = nil
+		},
+	}
+}
+
+// MultiFrame controls whether the named encoding may contain multiple concatenated frames,
+// such as gzip members. When disabled, any data following the first frame returns a BadRequestError,
+// allowing operators to reject trailing frames as potential smuggling. Encodings which aren't
+// configured use their decoder's default, which for gzip and zstd is to decode all frames.
+func MultiFrame(name string, enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			// Copy so per-request overrides don't modify the middleware defaults.
+			multiFrame := maps.Clone(opts.multiFrame)
+			if multiFrame == nil {
+				multiFrame = make(map[string]bool)
+			}
+			multiFrame[strings.ToLower(name)] = enable
+			opts.multiFrame = multiFrame
+		},
+	}
+}
+
+// DecodeTransferEncoding decodes transfer-codings other than "chunked" listed in the request's
+// TransferEncoding, such as "gzip" from "Transfer-Encoding: gzip, chunked", using the same supported
+// encodings as the Content-Encoding header. Transfer-codings are decoded before any content-codings,
+// and unsupported transfer-codings return a RequestUnsupportedMediaTypeError.
+//
+// The net/http server rejects requests with transfer-codings other than "chunked", so this only
+// applies to requests from other transports or rewritten by proxies. This is disabled by default.
+func DecodeTransferEncoding(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.decodeTransferEncoding = enable
+		},
+	}
+}
+
+// transferCodings returns the request's transfer-codings, in the order they were applied,
+// excluding "chunked" which net/http has already decoded.
+func transferCodings(r *http.Request) []string {
+	var codings []string
+	for _, coding := range r.TransferEncoding {
+		if coding = strings.TrimSpace(coding); !strings.EqualFold(coding, "chunked") {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// RequireContentLength will require the request to have a Content-Length header
+// set to a non-negative value, if set to true.
+func RequireContentLength(require bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.requireContentLength = require
+		},
+	}
+}
+
+// RejectChunked rejects requests using the chunked transfer-coding with a RequestContentLengthRequiredError,
+// regardless of the Content-Length reported by net/http. Unlike RequireContentLength, which rejects any request
+// without a known length, this rejects chunked requests even when the length is known, such as when a proxy has
+// buffered the body and set both headers, and allows HTTP/2 requests without a declared length.
+// This is disabled by default.
+func RejectChunked(reject bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rejectChunked = reject
+		},
+	}
+}
+
+// isChunked reports whether the request uses the chunked transfer-coding.
+func isChunked(r *http.Request) bool {
+	return slices.ContainsFunc(r.TransferEncoding, func(coding string) bool {
+		return strings.EqualFold(strings.TrimSpace(coding), "chunked")
+	})
+}
+
+// LengthRequiredStatus overrides the status code recommended by RequestContentLengthRequiredError,
+// which is used by the built-in error handlers. Some APIs prefer 400 Bad Request over 411 Length Required.
+// The default is 411 Length Required, which can be restored by passing zero.
+func LengthRequiredStatus(code int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.lengthRequiredStatus = code
+		},
+	}
+}
+
+// ContentTooLargeStatus overrides the status code recommended by RequestContentTooLargeError,
+// which is used by the built-in error handlers. Some clients mishandle 413 Content Too Large, so
+// prefer 400 Bad Request. The default is 413 Content Too Large, which can be restored by passing zero.
+func ContentTooLargeStatus(code int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.contentTooLargeStatus = code
+		},
+	}
+}
+
+// RejectMalformedContentLength returns a MalformedContentLengthError rather than a
+// RequestContentLengthRequiredError when RequireContentLength is set and the request has a Content-Length
+// header which is present but can't be parsed, so a malformed length is reported as 400 Bad Request rather
+// than as missing. net/http usually rejects malformed lengths before the handler is called, so this mostly
+// applies to requests constructed or forwarded by other means.
+// This is disabled by default.
+func RejectMalformedContentLength(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rejectMalformedLength = enable
+		},
+	}
+}
+
+// AdvertiseAcceptEncoding sets the Accept-Encoding header listing the supported encodings on every response,
+// rather than only for OPTIONS requests, so clients can discover the supported encodings from any response.
+// When a request is rejected with a RequestUnsupportedMediaTypeError because of its Content-Encoding, the
+// header lists the encodings supported for that request, including any per-request overrides.
+// This is disabled by default.
+// This option only has an effect when passed to RequestBodyHandler.
+func AdvertiseAcceptEncoding(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.advertiseAcceptEncoding = enable
+		},
+	}
+}
+
+// HandleOptionsDirectly will respond to OPTIONS requests with 204 No Content and the
+// Accept-Encoding header, without calling the wrapped handler, if set to true.
+// This option only has an effect when passed to RequestBodyHandler.
+func HandleOptionsDirectly(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.handleOptionsDirectly = enable
+		},
+	}
+}
+
+// OptionsResponseBody sets the body of OPTIONS responses written when HandleOptionsDirectly is enabled,
+// such as a JSON document describing the supported encodings and limits. The response is sent with
+// 200 OK rather than 204 No Content, and the Content-Type is application/json if the body is valid JSON,
+// otherwise it's detected from the body.
+// This option only has an effect when passed to RequestBodyHandler.
+func OptionsResponseBody(body []byte) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.optionsResponseBody = body
+		},
+	}
+}
+
+// OptionsAllowMethods sets the methods listed in the Allow header of OPTIONS responses written when
+// HandleOptionsDirectly is enabled, such as "GET, POST, OPTIONS". The header is omitted by default.
+// This option only has an effect when passed to RequestBodyHandler.
+func OptionsAllowMethods(methods ...string) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.optionsAllowMethods = slices.Clone(methods)
+		},
+	}
+}
+
+func writeOptionsResponse(w http.ResponseWriter, body []byte) {
+	if len(body) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	contentType := "application/json"
+	if !json.Valid(body) {
+		contentType = http.DetectContentType(body)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// DynamicOptionsAdvertise computes the encodings advertised in the Accept-Encoding header of
+// OPTIONS responses from the request, such as varying them by tenant. If the function returns nil,
+// the supported encodings are advertised as usual.
+// This option only has an effect when passed to RequestBodyHandler.
+func DynamicOptionsAdvertise(advertise func(r *http.Request) []string) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.dynamicAdvertise = advertise
+		},
+	}
+}
+
+// MaxEncodingTokens limits the number of comma-separated tokens in the Content-Encoding header,
+// which is checked before any tokens are parsed or decoders are constructed.
+// If the header contains more tokens, a BadRequestError will be returned.
+// The limit is disabled by default, or when set to zero or less.
+func MaxEncodingTokens(n int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxEncodingTokens = n
+		},
+	}
+}
+
+// MaxEncodingLayers limits the number of content-codings listed in the Content-Encoding header, and so
+// the number of decoders stacked to read the body, which is checked before any decoders are constructed.
+// If the header lists more codings, a BadRequestError will be returned.
+// The default limit is 3, and the limit is disabled when set to zero or less.
+func MaxEncodingLayers(n int) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.maxEncodingLayers = n
+		},
+	}
+}
+
+// SupportEncoding adds a new encoding to the list of supported encodings.
+// Names are case-insensitive, matching the Content-Encoding header in any case.
+// If the encoding already exists, it will be replaced.
+func SupportEncoding(name string, reader EncodingReader) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.setEncoding(name, &encoding{
+				reader: reader,
+				alias:  false,
+			})
+		},
+	}
+}
+
+// SupportEncodingFunc adds a new encoding whose reader receives the request being read, as SupportEncoding
+// does for readers which only need the body.
+// Names are case-insensitive, matching the Content-Encoding header in any case.
+// If the encoding already exists, it will be replaced.
+func SupportEncodingFunc(name string, reader RequestEncodingReader) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.setEncoding(name, &encoding{
+				requestReader: reader,
+				alias:         false,
+			})
+		},
+	}
+}
+
+// SupportEncodingPrefix adds an encoding for any content-coding starting with the prefix,
+// such as vendor codings like "vnd.acme.gzip" mapping to a base codec using the prefix "vnd.acme.".
+// Exact matches from SupportEncoding are always resolved first, then the longest matching prefix.
+// Prefix encodings aren't advertised in the Accept-Encoding header.
+// If the prefix already exists, it will be replaced.
+func SupportEncodingPrefix(prefix string, reader EncodingReader) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			prefix := strings.ToLower(prefix)
+			// Copy so per-request overrides don't modify the middleware defaults.
+			prefixEncodings := slices.DeleteFunc(slices.Clone(opts.prefixEncodings), func(p prefixEncoding) bool {
+				return p.prefix == prefix
+			})
+			opts.prefixEncodings = append(prefixEncodings, prefixEncoding{
+				prefix: prefix,
+				// Prefixes can't be advertised, so are treated as aliases.
+				encoding: encoding{reader: reader, alias: true},
+			})
+		},
+	}
+}
+
+// SupportEncodingNonChainable adds a new encoding which must be the only encoding applied to the body,
+// such as a stateful codec which can't safely be combined with other encodings.
+// If the encoding is combined with any other encoding in the Content-Encoding header,
+// a BadRequestError will be returned. If the encoding already exists, it will be replaced.
+func SupportEncodingNonChainable(name string, reader EncodingReader) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.setEncoding(name, &encoding{
+				reader:       reader,
+				alias:        false,
+				nonChainable: true,
+			})
+		},
+	}
+}
+
+// StrictEncodingParsing rejects Content-Encoding tokens with parameters, such as "gzip;q=1.0", with a
+// BadRequestError, as content-codings don't have parameters. By default, the parameters are ignored
+// and the coding before them is used, for compatibility with clients which wrongly send quality values.
+func StrictEncodingParsing(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.strictEncodingParsing = enable
+		},
+	}
+}
+
+// StrictAdvertisedEncodings will only accept the encodings advertised in the Accept-Encoding header
+// of OPTIONS responses, if set to true. Aliases such as "x-gzip" and encodings matched using
+// SupportEncodingPrefix return a RequestUnsupportedMediaTypeError, which helps diagnose clients
+// drifting from the negotiated encodings. Encodings advertised using DynamicOptionsAdvertise
+// aren't taken into account.
+func StrictAdvertisedEncodings(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.strictAdvertisedEncodings = enable
+		},
+	}
+}
+
+// PassthroughUnknownEncoding accepts bodies with an unsupported coding, rather than returning a
+// RequestUnsupportedMediaTypeError, if set to true. No decoders are applied, not even for the supported
+// codings, so the handler receives the body exactly as it was sent and the first unsupported coding is
+// available using UnhandledEncoding. AppliedEncodings is empty for these bodies.
+//
+// This is disabled by default. Only enable it for handlers which treat the body as opaque or decode it
+// themselves, as the protections of the middleware then apply to the encoded bytes: the content length
+// limit doesn't bound the decoded size, and RejectMislabeledContentType and RejectControlCharacters
+// aren't applied, so a handler which decodes the body must apply its own limits to avoid decompression bombs.
+func PassthroughUnknownEncoding(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.passthroughUnknownEncoding = enable
+		},
+	}
+}
+
+// DisableEncoding removes the specified encoding from the list of supported encodings.
+// If the encoding is not supported, it will have no effect.
+func DisableEncoding(name string) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			if _, ok := opts.supportedEncodings[strings.ToLower(name)]; !ok {
+				return // No encoding to disable.
+			}
+			opts.setEncoding(name, nil)
+		},
+	}
+}
+
+// SetRequestBodyOption sets options for the request body handler on the request context.
+// These options will override the default options set in the RequestBodyHandler middleware.
+// This allows handlers to customize the behaviour of the request body processing
+// on a per-request basis. It does nothing if the request wasn't wrapped by the middleware,
+// and nil options are ignored.
+//
+// Options must be set before the body is first read, as the body is prepared for reading,
+// including checking the declared content length and constructing decoders, on the first read.
+// The exception is ContentLengthLimit, which is also applied to the remaining bytes of the body
+// when changed after reading has begun. If more bytes have already been read than the new limit
+// allows, the next read returns a RequestContentTooLargeError. Use OnLateOptions to detect other
+// options being set too late.
+func SetRequestBodyOption(r *http.Request, opts ...Option) {
+	if r == nil {
+		return
+	}
+	if body, ok := bodyFromRequest(r); ok {
+		// The body is prepared on the first read, or by the middleware when rejecting a smuggling attempt.
+		prepared := body.initialized || body.initErr != nil
+		onLateOptions := body.options.onLateOptions
+		late := false
+		for _, opt := range opts {
+			if opt == nil {
+				continue
+			}
+			if prepared && !appliesAfterInit(opt) {
+				late = true
+			}
+			opt.apply(&body.options)
+		}
+		if late && onLateOptions != nil {
+			onLateOptions(r)
+		}
+	}
+}
+
+// appliesAfterInit returns true if the option still takes effect after the body has been prepared for reading.
+func appliesAfterInit(opt Option) bool {
+	switch opt := opt.(type) {
+	case optionFunc:
+		return opt.appliesAfterInit
+	case Options:
+		for _, o := range opt {
+			if o != nil && !appliesAfterInit(o) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// bodyFromRequest returns the lazyReader installed by the RequestBodyHandler middleware, if any.
+func bodyFromRequest(r *http.Request) (*lazyReader, bool) {
+	if r == nil {
+		return nil, false
+	}
+	if ctx := r.Context(); ctx != nil {
+		if body, ok := ctx.Value(contextKey).(*lazyReader); ok {
+			return body, true
+		}
+	}
+	return nil, false
+}
+
+type optionFunc struct {
+	f func(*options)
+	// appliesAfterInit is set for options which are re-applied when changed after reading has begun.
+	appliesAfterInit bool
+}
+
+func (o optionFunc) apply(opts *options) {
+	o.f(opts)
+}
+
+type lazyReader struct {
+	once            sync.Once
+	headersOnce     sync.Once
+	headers         parsedHeaders
+	contentLength   int64
+	reader          io.ReadCloser
+	contentEncoding string
+	contentType     string
+	initErr         error
+	// chain is the decode chain before the content length limit is applied, set during init.
+	chain        io.ReadCloser
+	appliedLimit int64
+	// slot is held while decoding when using MaxConcurrentBodies.
+	slot bodySlot
+	// initialized is set once init has started, and appliedEncodings once it has constructed the decode chain.
+	initialized      bool
+	appliedEncodings []string
+	// encodingLimit is the most restrictive EncodingLimit of the applied codings, set during init.
+	encodingLimit int64
+	// stageLimited is set when the content length limit is applied within the decode chain, using
+	// LimitStage or DecompressedSizeLimit.
+	stageLimited bool
+	// raw counts the bytes read from the original request body, set during init.
+	raw *countingReader
+	// rawHash is the digest of the raw body when using ComputeRawDigest, set during init.
+	rawHash hash.Hash
+	// decoded is true when at least one decoder was applied to the raw body.
+	decoded bool
+	// decodedBytes counts the bytes returned by Read, and may be loaded concurrently using BytesRead.
+	decodedBytes atomic.Int64
+	stats        bodyStats
+	// decodeDuration is the time spent reading from the decode chain, only measured when using WithObserver.
+	decodeDuration time.Duration
+	// rejected is set once the observer has been notified of an error, and handled once an error handler
+	// has been called for an error.
+	rejected bool
+	handled  bool
+	// readDeadline is when reading must end when using ReadTimeout, set on the first read, and
+	// clearReadDeadline clears the connection's read deadline if it was set.
+	readDeadline      time.Time
+	clearReadDeadline func()
+	// rewind buffers the decoded body when using Rewindable, set during init.
+	rewind *rewindBuffer
+	// pooled are the decoders in the decode chain which are returned to a pool once the body is closed.
+	pooled []io.Closer
+	// unhandledEncoding is the unsupported coding left encoded when using PassthroughUnknownEncoding.
+	unhandledEncoding string
+	// decodeErr is the error which ended the body when using LenientDecode.
+	decodeErr *BadRequestError
+	// eof is set once the end of the body has been returned, and failed once an error has.
+	eof    bool
+	failed bool
+	// bufferedBytes is the total size of buffers held by buffering features for this request.
+	bufferedBytes int64
+	options       options
+	request       *http.Request
+	writer        http.ResponseWriter
+}
+
+func (r *lazyReader) Read(p []byte) (n int, err error) {
+	if r.rewind != nil && r.rewind.pos < len(r.rewind.data) {
+		// Return the bytes already read before rewinding, before reading the rest of the body.
+		return r.rewind.replay(p), nil
+	}
+	if ctxErr := r.request.Context().Err(); ctxErr != nil && !r.eof {
+		// The client has gone away, so stop rather than decoding a body nobody will use.
+		r.failed = true
+		return 0, r.handleError(&BadRequestError{Err: ctxErr})
+	}
+	if r.options.readTimeout > 0 && r.readDeadline.IsZero() {
+		r.startReadDeadline()
+	}
+	if !r.readDeadline.IsZero() && !r.eof && !time.Now().Before(r.readDeadline) {
+		r.failed = true
+		r.stopReadDeadline()
+		return 0, r.handleError(&RequestTimeoutError{Timeout: r.options.readTimeout})
+	}
+	r.init()
+
+	if r.initErr != nil {
+		return 0, r.handleError(r.initErr)
+	}
+
+	if r.chain != nil && r.decodedLimit() != r.appliedLimit {
+		// The limit was changed using SetRequestBodyOption after reading began.
+		if err := r.applyLimit(); err != nil {
+			r.failed = true
+			return 0, r.handleError(err)
+		}
+	}
+
+	r.stats.record(len(p))
+	if r.options.observer != nil {
+		start := time.Now()
+		n, err = r.reader.Read(p)
+		r.decodeDuration += time.Since(start)
+	} else {
+		n, err = r.reader.Read(p)
+	}
+	r.decodedBytes.Add(int64(n))
+	if r.rewind != nil {
+		r.recordRewind(p[:n])
+	}
+	if ratioErr := r.checkCompressionRatio(); ratioErr != nil && (err == nil || err == io.EOF) {
+		err = ratioErr
+	}
+	if err == io.EOF {
+		if eofErr := r.checkEOF(); eofErr != nil {
+			err = eofErr
+		}
+	}
+	if err != nil && err != io.EOF {
+		var bodyErr RequestBodyError
+		if errors.As(err, &bodyErr) {
+			// Errors raised by our own readers within the decode chain.
+			err = bodyErr
+		} else if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+			err = &RequestContentTooLargeError{
+				Limit: r.appliedLimit,
+				Read:  r.decodedBytes.Load(),
+			}
+		} else if !r.readDeadline.IsZero() && errors.Is(err, os.ErrDeadlineExceeded) {
+			// The connection's read deadline was reached while the client stalled.
+			err = &RequestTimeoutError{Timeout: r.options.readTimeout}
+		} else {
+			// Wrap other errors in a BadRequestError as we failed while reading the body.
+			badRequest := &BadRequestError{
+				Err: err,
+			}
+			if le := (*layerError)(nil); errors.As(err, &le) {
+				badRequest.Err, badRequest.Layer, badRequest.Coding = decodeError(le.coding, false, le.err), le.layer, le.coding
+			}
+			if r.decoded && r.options.lenientDecode {
+				// Deliver what was decoded so far, recording the error for DecodeError.
+				r.decodeErr = badRequest
+				err = io.EOF
+			} else {
+				err = badRequest
+			}
+		}
+	}
+	if err == io.EOF {
+		if !r.eof && r.decodeErr == nil {
+			if r.options.onBodyComplete != nil {
+				r.options.onBodyComplete(r.request, r.decodedBytes.Load(), r.contentEncoding)
+			}
+			r.observeDecoded()
+		}
+		r.eof = true
+		r.stopReadDeadline()
+	} else if err != nil {
+		r.failed = true
+		r.stopReadDeadline()
+	}
+	if err != nil {
+		return n, r.handleError(err)
+	}
+	return n, err
+}
+
+// drain discards the remainder of a body which wasn't fully consumed by the handler,
+// returning the number of decoded bytes discarded. Errors are ignored as the response
+// has already been written.
+func (r *lazyReader) drain() int64 {
+	if r.eof || r.failed {
+		return 0
+	}
+	r.init()
+	if r.initErr != nil {
+		return 0
+	}
+	// The decode chain is already limited to the max content length, if set.
+	drained, _ := io.Copy(io.Discard, r.reader)
+	return drained
+}
+
+func (r *lazyReader) init() {
+	r.once.Do(func() {
+		r.initialized = true
+		if r.options.rewindBuffer > 0 {
+			r.rewind = &rewindBuffer{max: r.options.rewindBuffer}
+		}
+		if r.options.rejectChunked && isChunked(r.request) {
+			r.initErr = &RequestContentLengthRequiredError{
+				status: r.options.lengthRequiredStatus,
+			}
+			return
+		}
+		if r.contentLength == 0 {
+			// There's nothing to decode, but unsupported encodings are rejected as they are for other bodies.
+			if err := r.checkEmptyBodyEncodings(); err != nil {
+				r.initErr = err
+			}
+			return
+		}
+
+		// Fail if content length not provided but is required.
+		if r.contentLength < 0 && r.options.requireContentLength {
+			if value := r.request.Header.Get("Content-Length"); r.options.rejectMalformedLength && malformedContentLength(value) {
+				r.initErr = &MalformedContentLengthError{Value: value}
+				return
+			}
+			r.initErr = &RequestContentLengthRequiredError{
+				status: r.options.lengthRequiredStatus,
+			}
+			return
+		}
+
+		if limit, ok := r.headerLimit(); ok {
+			r.options.maxContentLength = limit
+		}
+
+		// Fail fast if content length exceeds the maximum allowed limit.
+		if r.options.maxContentLength > -1 && r.contentLength > r.options.maxContentLength {
+			r.initErr = &RequestContentTooLargeError{
+				Limit: r.options.maxContentLength,
+			}
+			return
+		}
+		if err := r.checkContentType(); err != nil {
+			r.initErr = err
+			return
+		}
+		var encodings []namedEncoding
+		// Count the tokens before parsing them, as absurd token counts are cheap to send.
+		if limit := r.options.maxEncodingTokens; limit > 0 && r.contentEncoding != "" && strings.Count(r.contentEncoding, ",")+1 > limit {
+			r.initErr = &BadRequestError{
+				Err: fmt.Errorf("too many content-coding tokens: more than %d", limit),
+			}
+			return
+		}
+		if r.options.strictEncodingParsing {
+			for _, token := range strings.Split(r.contentEncoding, ",") {
+				if strings.Contains(token, ";") {
+					r.initErr = &BadRequestError{
+						Err: fmt.Errorf("content-coding %q has parameters", strings.TrimSpace(token)),
+					}
+					return
+				}
+			}
+		}
+		tokens := r.encodingTokens()
+		if len(tokens) > 0 {
+			// Limit the layers before constructing decoders, as each stacked decoder costs memory.
+			if limit := r.options.maxEncodingLayers; limit > 0 {
+				layers := 0
+				for _, token := range tokens {
+					if token != "" {
+						layers++
+					}
+				}
+				if layers > limit {
+					r.initErr = &BadRequestError{
+						Err: fmt.Errorf("too many content-codings: more than %d", limit),
+					}
+					return
+				}
+			}
+			for position, trimmed := range tokens {
+				if trimmed == "" {
+					// A header such as "gzip,,deflate" or "gzip," is malformed rather than unsupported.
+					r.initErr = &BadRequestError{
+						Err: errors.New("empty content-coding token"),
+					}
+					return
+				}
+				// Content-codings are case-insensitive, and registered in lowercase.
+				// https://www.rfc-editor.org/rfc/rfc9110.html#name-content-codings
+				name := strings.ToLower(trimmed)
+				encoder, supported := r.options.decodableEncoding(name)
+				if supported && r.options.onDeprecatedEncoding != nil && r.options.isDeprecated(name) {
+					r.options.onDeprecatedEncoding(r.request, name)
+				}
+				if supported {
+					if encoder.nonChainable && len(tokens) > 1 {
+						r.initErr = &BadRequestError{
+							Err: fmt.Errorf("encoding %s cannot be combined with other encodings", trimmed),
+						}
+						return
+					}
+					if encoder.identity {
+						continue // No transformation was applied, so there's nothing to decode.
+					}
+					reader := encoder.reader
+					if requestReader := encoder.requestReader; requestReader != nil {
+						reader = func(input io.Reader) (io.ReadCloser, error) {
+							return requestReader(input, r.request)
+						}
+					}
+					encodings = append(encodings, namedEncoding{name: name, reader: reader, layer: position + 1})
+				} else if r.options.passthroughUnknownEncoding {
+					// Leave the body as sent, as the codings before this one can't be decoded without it.
+					r.unhandledEncoding = trimmed
+					encodings = nil
+					break
+				} else {
+					r.initErr = r.unsupportedEncoding(position, trimmed)
+					return
+				}
+			}
+		}
+
+		r.encodingLimit = r.options.encodingLimitFor(encodings)
+		applied := make([]string, 0, len(encodings))
+		for _, encoding := range encodings {
+			applied = append(applied, encoding.name)
+		}
+		if semaphore := r.slot.semaphore; semaphore != nil && len(encodings) > 0 {
+			if err := semaphore.acquire(r.request.Context(), r.options.maxConcurrentBodiesWait); err != nil {
+				r.initErr = err
+				return
+			}
+			r.slot.held.Store(true)
+		}
+
+		r.raw = &countingReader{ReadCloser: r.reader}
+		if r.options.rawDigest != nil {
+			r.rawHash = r.options.rawDigest()
+			r.raw.ReadCloser = &hashReader{ReadCloser: r.reader, hash: r.rawHash}
+		}
+		var reader io.ReadCloser = r.raw
+		if budget := r.newClientBudget(); budget != nil {
+			if r.contentLength > 0 {
+				// Charge the declared length up front, so the body isn't read if the budget is exhausted.
+				if err := budget.charge(r.contentLength); err != nil {
+					r.initErr = err
+					return
+				}
+			} else {
+				reader = &clientBudgetReader{ReadCloser: reader, budget: budget}
+			}
+		}
+		r.decoded = len(encodings) > 0
+		var budget *decodeBudget
+		if r.options.decodeByteBudget > 0 {
+			budget = &decodeBudget{limit: r.options.decodeByteBudget}
+		}
+		slices.Reverse(encodings) // Reverse the order to apply the last encoding first.
+		stage := r.options.limitStage
+		if stage < 0 && r.options.decompressedSizeLimit > 0 {
+			stage = 0 // The content length limit applies to the raw body.
+		} else if stage < 0 || stage > len(encodings) {
+			stage = len(encodings)
+		}
+		limitStage := func() {
+			if r.options.maxContentLength > 0 && !r.lengthTrusted(r.options.maxContentLength) {
+				// Limit the input to this stage of the decode chain rather than the final output.
+				reader = &stageLimitReader{
+					ReadCloser: http.MaxBytesReader(r.writer, reader, r.options.maxContentLength),
+					limit:      r.options.maxContentLength,
+				}
+				r.stageLimited = true
+			}
+		}
+		deadline, stopDeadline := r.startInitDeadline(len(encodings))
+		defer stopDeadline()
+		// Unwrap each encoding reader in the order they were provided.
+		for i, encoding := range encodings {
+			if i == stage {
+				limitStage()
+			}
+			layer := encoding.layer
+			var input io.Reader = reader
+			if budget != nil {
+				input = &budgetReader{reader: reader, budget: budget}
+			}
+			if chunk := r.options.decoderReadChunk; chunk > 0 {
+				input = &chunkReader{reader: input, size: chunk}
+			}
+			var frameInput *bufio.Reader
+			if multiFrame, ok := r.options.multiFrame[encoding.name]; ok && !multiFrame {
+				// Decoders use an io.ByteReader as-is, so trailing data stays visible to us.
+				frameInput = bufio.NewReader(input)
+				input = frameInput
+			}
+			// Apply each encoding reader to the reader.
+			wrappedReader, err := deadline.newDecoder(encoding.reader, input)
+			if err == nil && wrappedReader == nil {
+				// Fail closed on a misbehaving custom EncodingReader, rather than panicking on the first read.
+				r.initErr = &BadRequestError{
+					Err:    fmt.Errorf("failed to create encoding reader for %s: encoding reader returned a nil reader", r.contentEncoding),
+					Layer:  layer,
+					Coding: encoding.name,
+				}
+				return
+			}
+			if err == nil && r.options.inspectGzipExtra != nil {
+				if gz, ok := asGzipReader(wrappedReader); ok {
+					if extraErr := r.options.inspectGzipExtra(gz.Header.Extra); extraErr != nil {
+						r.initErr = &BadRequestError{
+							Err: fmt.Errorf("invalid gzip extra field: %w", extraErr),
+						}
+						return
+					}
+				}
+			}
+			if err == nil && frameInput != nil {
+				if gz, ok := asGzipReader(wrappedReader); ok {
+					gz.Multistream(false)
+				}
+				switch decoder := wrappedReader.(type) {
+				case *zstdReader:
+					decoder.input = &zstdFrameReader{reader: frameInput}
+				}
+				wrappedReader = &singleFrameReader{ReadCloser: wrappedReader, input: frameInput, name: encoding.name}
+			}
+			if err != nil {
+				var bodyErr RequestBodyError
+				if errors.As(err, &bodyErr) {
+					r.initErr = bodyErr
+					return
+				}
+				var mbe *http.MaxBytesError
+				if errors.As(err, &mbe) {
+					r.initErr = &RequestContentTooLargeError{
+						Limit: r.appliedLimit,
+					}
+					return
+				}
+				if !r.readDeadline.IsZero() && errors.Is(err, os.ErrDeadlineExceeded) {
+					r.initErr = &RequestTimeoutError{Timeout: r.options.readTimeout}
+					return
+				}
+				r.initErr = &BadRequestError{
+					Err:    decodeError(encoding.name, true, err),
+					Layer:  layer,
+					Coding: encoding.name,
+				}
+				return
+			}
+			if isPooled(wrappedReader) {
+				r.pooled = append(r.pooled, wrappedReader)
+			}
+			wrappedReader = &layerReader{ReadCloser: wrappedReader, layer: layer, coding: encoding.name}
+			if limit := r.options.deflateOutputLimit; limit > 0 && encoding.name == "deflate" {
+				// Raw deflate has no length framing, so always cap its output when configured.
+				wrappedReader = &stageLimitReader{
+					ReadCloser: http.MaxBytesReader(r.writer, wrappedReader, limit),
+					limit:      limit,
+				}
+			}
+			reader = wrappedReader
+		}
+		if stage == len(encodings) && r.options.decompressedSizeLimit > 0 {
+			// The stage is the final output, limited alongside the decompressed limit.
+			limitStage()
+		}
+		if declared := r.parsedHeaders().mediaType; r.options.rejectMislabeledContentType && declared != "" && r.unhandledEncoding == "" {
+			reader = &sniffReader{ReadCloser: reader, declared: declared}
+		}
+		if r.options.rejectControlCharacters && r.unhandledEncoding == "" {
+			reader = &controlCharReader{ReadCloser: reader}
+		}
+		r.chain = reader
+		r.applyLimit()
+		r.appliedEncodings = applied
+	})
+}
+
+// encodingTokens returns the content-coding tokens, followed by any transfer-codings to decode.
+func (r *lazyReader) encodingTokens() []string {
+	var tokens []string
+	if r.contentEncoding != "" {
+		tokens = r.parsedHeaders().encodings
+	}
+	if r.options.decodeTransferEncoding {
+		// Transfer-codings are applied after the content-codings, so are decoded first.
+		tokens = append(slices.Clip(tokens), transferCodings(r.request)...)
+	}
+	return tokens
+}
+
+// decodedLimit returns the limit on the decoded body, which is the most restrictive EncodingLimit of the
+// applied codings, or the DecompressedSizeLimit if set, otherwise the content length limit unless it's
+// already applied within the decode chain.
+func (r *lazyReader) decodedLimit() int64 {
+	if r.encodingLimit > 0 {
+		return r.encodingLimit
+	}
+	if r.options.decompressedSizeLimit > 0 {
+		return r.options.decompressedSizeLimit
+	}
+	if r.stageLimited {
+		return -1
+	}
+	return r.options.maxContentLength
+}
+
+// applyLimit wraps the decode chain to enforce the current max content length on the
+// remaining decoded bytes. It's re-applied if the limit is changed after reading has begun.
+func (r *lazyReader) applyLimit() error {
+	r.reader = r.chain
+	r.appliedLimit = r.decodedLimit()
+	if r.appliedLimit > 0 && !r.lengthTrusted(r.appliedLimit) {
+		remaining := r.appliedLimit - r.decodedBytes.Load()
+		if remaining < 0 {
+			return &RequestContentTooLargeError{
+				Limit: r.appliedLimit,
+				Read:  r.decodedBytes.Load(),
+			}
+		}
+		// Limit the reader to the specified max content length.
+		r.reader = http.MaxBytesReader(r.writer, r.chain, remaining)
+	}
+	return nil
+}
+
+// unsupportedEncoding returns the error for an unsupported coding at the position in the encoding tokens.
+func (r *lazyReader) unsupportedEncoding(position int, coding string) *RequestUnsupportedMediaTypeError {
+	// If the encoding is not supported, return 415 Unsupported Media Type.
+	// https://www.rfc-editor.org/rfc/rfc9110.html#name-415-unsupported-media-type
+	header := "Content-Encoding"
+	if position >= len(r.parsedHeaders().encodings) {
+		header = "Transfer-Encoding" // Appended when using DecodeTransferEncoding.
+	}
+	return &RequestUnsupportedMediaTypeError{
+		Encoding:  coding,
+		Supported: r.options.advertisedEncodings(),
+		Header:    header,
+		Value:     coding,
+	}
+}
+
+// checkEmptyBodyEncodings rejects malformed or unsupported codings for a body declared to be empty,
+// without constructing any decoders.
+func (r *lazyReader) checkEmptyBodyEncodings() RequestBodyError {
+	for position, token := range r.encodingTokens() {
+		if token == "" {
+			return &BadRequestError{
+				Err: errors.New("empty content-coding token"),
+			}
+		}
+		if _, supported := r.options.decodableEncoding(strings.ToLower(token)); !supported {
+			if r.options.passthroughUnknownEncoding {
+				r.unhandledEncoding = token
+				return nil
+			}
+			return r.unsupportedEncoding(position, token)
+		}
+	}
+	return nil
+}
+
+// malformedContentLength reports whether a Content-Length header value is present but isn't a valid
+// non-negative decimal length.
+func malformedContentLength(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(value, 10, 63)
+	return err != nil
+}
+
+// lengthTrusted reports whether the declared length of an unencoded body is within the limit and trusted
+// to bound the body using TrustContentLength, so the body doesn't need to be limited as it's read.
+func (r *lazyReader) lengthTrusted(limit int64) bool {
+	return r.options.trustContentLength && !r.decoded && r.contentLength >= 0 && r.contentLength <= limit
+}
+
+// bufferLimit returns the number of bytes which may still be buffered for this request, or the
+// maximum if less, along with the limit to report if it's exceeded.
+func (r *lazyReader) bufferLimit(maximum int64) (allowed int64, limit int64) {
+	if r.options.maxTotalBufferBytes > 0 {
+		if remaining := r.options.maxTotalBufferBytes - r.bufferedBytes; remaining < maximum {
+			return max(remaining, 0), r.options.maxTotalBufferBytes
+		}
+	}
+	return maximum, maximum
+}
+
+// chargeBuffer records n more bytes held in memory by a buffering feature, returning a
+// RequestContentTooLargeError if this would take the total over the MaxTotalBufferBytes limit.
+// It does nothing when r is nil, for requests which weren't wrapped by the middleware.
+func (r *lazyReader) chargeBuffer(n int64) RequestBodyError {
+	if r == nil {
+		return nil
+	}
+	if limit := r.options.maxTotalBufferBytes; limit > 0 && n > limit-r.bufferedBytes {
+		return &RequestContentTooLargeError{
+			Limit: limit,
+			Read:  r.bufferedBytes + n,
+		}
+	}
+	r.bufferedBytes += n
+	return nil
+}
+
+// releaseBuffer records that n bytes charged using chargeBuffer are no longer held.
+func (r *lazyReader) releaseBuffer(n int64) {
+	if r != nil {
+		r.bufferedBytes -= n
+	}
+}
+
+// readAtMost reads src to the end, stopping once more than allowed bytes have been read,
+// and reports whether the data fitted within allowed.
+func readAtMost(src io.Reader, allowed int64) (data []byte, fits bool, err error) {
+	if allowed == math.MaxInt64 {
+		// There's no room for the extra byte used to detect when the limit is exceeded.
+		data, err = io.ReadAll(src)
+		return data, true, err
+	}
+	data, err = io.ReadAll(io.LimitReader(src, allowed+1))
+	return data, int64(len(data)) <= allowed, err
+}
+
+// checkCompressionRatio returns an error once the decoded bytes exceed the MaxCompressionRatio of the raw bytes.
+func (r *lazyReader) checkCompressionRatio() RequestBodyError {
+	ratio := r.options.maxCompressionRatio
+	if ratio <= 0 || !r.decoded {
+		return nil
+	}
+	allowed := ratio * float64(max(r.raw.n, compressionRatioMinInput))
+	if decoded := r.decodedBytes.Load(); float64(decoded) > allowed {
+		return &RequestContentTooLargeError{
+			Limit: int64(allowed),
+			Read:  decoded,
+		}
+	}
+	return nil
+}
+
+// checkEOF validates the body once the end has been reached.
+func (r *lazyReader) checkEOF() RequestBodyError {
+	if r.options.maxFinalRatio > 0 && r.decoded && r.raw.n > 0 {
+		if float64(r.decodedBytes.Load())/float64(r.raw.n) > r.options.maxFinalRatio {
+			return &RequestContentTooLargeError{
+				Limit: int64(r.options.maxFinalRatio * float64(r.raw.n)),
+				Read:  r.decodedBytes.Load(),
+			}
+		}
+	}
+	if r.options.minCompressionRatio > 0 && r.decoded && r.raw.n > 0 {
+		if ratio := float64(r.decodedBytes.Load()) / float64(r.raw.n); ratio < r.options.minCompressionRatio {
+			return &BadRequestError{
+				Err: fmt.Errorf("compression ratio %.2f is below the minimum of %.2f", ratio, r.options.minCompressionRatio),
+			}
+		}
+	}
+	if r.options.enforceContentLengthMatch && !r.decoded && r.contentLength > 0 && r.raw.n != r.contentLength {
+		return &BadRequestError{
+			Err: fmt.Errorf("read %d bytes but the declared content length is %d", r.raw.n, r.contentLength),
+		}
+	}
+	if tolerance := r.options.declaredLengthTolerance; tolerance > 0 && r.contentLength > 0 {
+		if minimum := float64(r.contentLength) * (1 - tolerance); float64(r.raw.n) < minimum {
+			return &BadRequestError{
+				Err: fmt.Errorf("read %d bytes of the declared content length %d", r.raw.n, r.contentLength),
+			}
+		}
+	}
+	return nil
 }
 
 func (r *lazyReader) Close() error {
+	r.slot.release()
 	if r.initErr != nil {
-		return handleError(r.options.handleError, r.initErr)
+		if r.handled {
+			// The error handler has already written the response, such as when closing in a deferred call
+			// while the panic from a read is unwinding, so don't panic again.
+			return r.initErr
+		}
+		return r.handleError(r.initErr)
+	}
+	err := r.reader.Close()
+	r.releaseDecoders()
+	return err
+}
+
+// releaseDecoders returns any pooled decoders to their pools, including those wrapped by other layers
+// which aren't closed when the outermost layer is.
+func (r *lazyReader) releaseDecoders() {
+	for _, decoder := range r.pooled {
+		_ = decoder.Close()
+	}
+	r.pooled = nil
+}
+
+type namedEncoding struct {
+	name   string
+	reader EncodingReader
+	// layer is the 1-based position of the coding in the header.
+	layer int
+}
+
+// singleFrameReader rejects any data following the first frame of the decoded stream.
+type singleFrameReader struct {
+	io.ReadCloser
+	input *bufio.Reader
+	name  string
+}
+
+func (s *singleFrameReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	if err == io.EOF {
+		if _, peekErr := s.input.Peek(1); peekErr == nil {
+			return n, &BadRequestError{
+				Err: fmt.Errorf("unexpected data after the first %s frame", s.name),
+			}
+		}
+	}
+	return n, err
+}
+
+// layerReader records which layer of the decode chain returned an error while decoding.
+type layerReader struct {
+	io.ReadCloser
+	layer  int
+	coding string
+}
+
+func (l *layerReader) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		if le := (*layerError)(nil); !errors.As(err, &le) {
+			// Errors are passed through the following decoders, so keep the layer which returned it first.
+			err = &layerError{err: err, layer: l.layer, coding: l.coding}
+		}
+	}
+	return n, err
+}
+
+type layerError struct {
+	err    error
+	layer  int
+	coding string
+}
+
+// decodeError describes an error decoding the coding, distinguishing a stream which ended early from one which
+// was malformed at its header when constructing the decoder, or was corrupt while reading.
+func decodeError(coding string, constructing bool, err error) error {
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("truncated %s stream: %w", coding, err)
+	case constructing:
+		return fmt.Errorf("malformed %s header: %w", coding, err)
+	default:
+		return fmt.Errorf("corrupt %s stream: %w", coding, err)
+	}
+}
+
+func (e *layerError) Error() string {
+	return e.err.Error()
+}
+func (e *layerError) Unwrap() error {
+	return e.err
+}
+
+// stageLimitReader reports the content length limit applied within the decode chain, rather than
+// the limit on the decoded body.
+type stageLimitReader struct {
+	io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (s *stageLimitReader) Read(p []byte) (int, error) {
+	n, err := s.ReadCloser.Read(p)
+	s.read += int64(n)
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		err = &RequestContentTooLargeError{
+			Limit: s.limit,
+			Read:  s.read,
+		}
+	}
+	return n, err
+}
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBudget is shared by all decoder inputs of a single request.
+type decodeBudget struct {
+	limit int64
+	used  int64
+}
+
+// budgetReader counts the bytes fed into a decoder against the shared decode budget.
+type budgetReader struct {
+	reader io.Reader
+	budget *decodeBudget
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	remaining := b.budget.limit - b.budget.used
+	if remaining < 0 {
+		return 0, &RequestContentTooLargeError{
+			Limit: b.budget.limit,
+			Read:  b.budget.limit,
+		}
+	}
+	// Read one byte beyond the remaining budget so we can detect when it's exceeded.
+	if remaining < math.MaxInt64 && int64(len(p)) > remaining+1 {
+		p = p[:remaining+1]
+	}
+	n, err := b.reader.Read(p)
+	if int64(n) > remaining {
+		// Withhold the excess byte so the decoder has to ask again and observe the error.
+		b.budget.used = b.budget.limit + 1
+		return int(remaining), &RequestContentTooLargeError{
+			Limit: b.budget.limit,
+			Read:  b.budget.limit,
+		}
+	}
+	b.budget.used += int64(n)
+	return n, err
+}
+
+// chunkReader limits the size of each read from the wrapped reader.
+type chunkReader struct {
+	reader io.Reader
+	size   int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.size {
+		p = p[:c.size]
 	}
-	return r.reader.Close()
+	return c.reader.Read(p)
 }
 
 type bodyErrorPanic struct {
@@ -4,11 +4,21 @@ import (
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 )
 
 func TestProcessBody(t *testing.T) {
@@ -27,7 +37,96 @@ func TestProcessBody(t *testing.T) {
 		assertNoError(t, err)
 		defer response.Body.Close()
 		assertEqual(t, http.StatusOK, response.StatusCode)
-		assertEqual(t, "deflate, gzip", response.Header.Get("Accept-Encoding"))
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header.Get("Accept-Encoding"))
+	})
+
+	t.Run("options handled directly", func(t *testing.T) {
+		t.Parallel()
+		var handlerCalled atomic.Bool
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			handlerCalled.Store(true)
+			w.WriteHeader(http.StatusNotFound)
+		}
+		ts := setupServer(t, handler, HandleOptionsDirectly(true))
+
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusNoContent, response.StatusCode)
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header.Get("Accept-Encoding"))
+		assertEqual(t, false, handlerCalled.Load())
+	})
+
+	t.Run("options handled directly with body", func(t *testing.T) {
+		t.Parallel()
+		capabilities := []byte(`{"encodings":["deflate","gzip"],"maxContentLength":10485760}`)
+		ts := setupServer(t, echoHandler(), HandleOptionsDirectly(true), OptionsResponseBody(capabilities))
+
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "application/json", response.Header.Get("Content-Type"))
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header.Get("Accept-Encoding"))
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, capabilities, responseBody)
+	})
+
+	t.Run("options handled directly with allow", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleOptionsDirectly(true), OptionsAllowMethods("GET", "POST", "OPTIONS"))
+
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusNoContent, response.StatusCode)
+		assertEqual(t, "GET, POST, OPTIONS", response.Header.Get("Allow"))
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header.Get("Accept-Encoding"))
+	})
+
+	t.Run("options handled directly without allow", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleOptionsDirectly(true))
+
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, []string(nil), response.Header.Values("Allow"))
+	})
+
+	t.Run("options advertised dynamically", func(t *testing.T) {
+		t.Parallel()
+		advertise := func(r *http.Request) []string {
+			if r.Header.Get("X-Tenant") == "legacy" {
+				return []string{"gzip"}
+			}
+			return nil
+		}
+		ts := setupServer(t, echoHandler(), DynamicOptionsAdvertise(advertise))
+
+		for tenant, expected := range map[string]string{"legacy": "gzip", "modern": strings.Join(defaultEncodings(), ", ")} {
+			req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+			assertNoError(t, err)
+			req.Header.Set("X-Tenant", tenant)
+			response, err := ts.Client().Do(req)
+
+			assertNoError(t, err)
+			response.Body.Close()
+			assertEqual(t, expected, response.Header.Get("Accept-Encoding"))
+		}
 	})
 
 	t.Run("default post at limit", func(t *testing.T) {
@@ -174,6 +273,20 @@ func TestProcessBody(t *testing.T) {
 		assertEqual(t, http.StatusLengthRequired, response.StatusCode)
 	})
 
+	t.Run("require content length missing with overridden status", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), RequireContentLength(true), LengthRequiredStatus(http.StatusBadRequest))
+
+		body := io.NopCloser(bytes.NewBuffer(make([]byte, 100)))
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
 	t.Run("override handler limit", func(t *testing.T) {
 		t.Parallel()
 		var limit int64 = 100
@@ -211,30 +324,3156 @@ func TestProcessBody(t *testing.T) {
 	})
 }
 
-func setupServer(t *testing.T, h http.HandlerFunc, globalDefaults ...Option) *httptest.Server {
-	t.Helper()
+func TestPerRequestReturnOnError(t *testing.T) {
+	t.Parallel()
 
-	var handler http.Handler = h
-	handler = RequestBodyHandler(handler, globalDefaults...)
-	ts := httptest.NewServer(handler)
-	t.Cleanup(ts.Close)
-	return ts
+	onRequestBodyError := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/return" {
+			SetRequestBodyOption(r, ReturnOnError())
+		}
+		_, err := io.ReadAll(r.Body)
+		if tooLarge, ok := err.(*RequestContentTooLargeError); ok {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(tooLarge.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	ts := setupServer(t, handler, ContentLengthLimit(100), HandleRequestBodyError(onRequestBodyError))
+
+	t.Run("returned from read", func(t *testing.T) {
+		t.Parallel()
+		response, err := ts.Client().Post(ts.URL+"/return", "application/json",
+			io.NopCloser(bytes.NewBuffer(make([]byte, 101))))
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusConflict, response.StatusCode)
+		body, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, "Content Too Large: greater than 100 bytes", string(body))
+	})
+
+	t.Run("global handler for other requests", func(t *testing.T) {
+		t.Parallel()
+		response, err := ts.Client().Post(ts.URL+"/other", "application/json",
+			io.NopCloser(bytes.NewBuffer(make([]byte, 101))))
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusTeapot, response.StatusCode)
+	})
 }
 
-func echoHandler(options ...Option) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if len(options) > 0 {
-			SetRequestBodyOption(r, options...)
+func TestLimitChangedAfterRead(t *testing.T) {
+	t.Parallel()
+
+	readThenLimit := func(limit int64) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			first := make([]byte, 10)
+			if _, err := io.ReadFull(r.Body, first); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			SetRequestBodyOption(r, ContentLengthLimit(limit))
+			rest, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(first)
+			_, _ = w.Write(rest)
+		}
+	}
+	// Sent without a length, so the declared length doesn't fail fast.
+	post := func(t *testing.T, ts *httptest.Server) *http.Response {
+		t.Helper()
+		response, err := ts.Client().Post(ts.URL, "application/octet-stream",
+			io.NopCloser(bytes.NewBuffer(make([]byte, 100))))
+		assertNoError(t, err)
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	t.Run("lowered limit applies to remaining bytes", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, readThenLimit(50))
+
+		response := post(t, ts)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("lowered limit below bytes already read", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, readThenLimit(5))
+
+		response := post(t, ts)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("raised limit applies to remaining bytes", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, readThenLimit(200), ContentLengthLimit(60))
+
+		response := post(t, ts)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, 100, len(responseBody))
+	})
+
+	t.Run("limit removed after read", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, readThenLimit(-1), ContentLengthLimit(60))
+
+		response := post(t, ts)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestRecoverAllPanics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("non-body panic recovered", func(t *testing.T) {
+		t.Parallel()
+		recovered := make(chan any, 1)
+		panicHandler := func(w http.ResponseWriter, r *http.Request) {
+			panic("something went wrong")
 		}
+		ts := setupServer(t, panicHandler, RecoverAllPanics(func(w http.ResponseWriter, r *http.Request, v any) {
+			recovered <- v
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+
+		response, err := ts.Client().Get(ts.URL)
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusInternalServerError, response.StatusCode)
+		assertEqual(t, "something went wrong", <-recovered)
+	})
+
+	t.Run("body errors still use the error handler", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(10),
+			RecoverAllPanics(func(w http.ResponseWriter, r *http.Request, v any) {
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+
+		response, err := ts.Client().Post(ts.URL, "application/octet-stream", bytes.NewReader(make([]byte, 11)))
+
+		assertNoError(t, err)
+		defer response.Body.Close()
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
+
+func TestMaxEncodingTokens(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("hundreds of tokens", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxEncodingTokens(10))
+		header := strings.TrimSuffix(strings.Repeat("gzip, ", 500), ", ")
+
+		response := postEncoded(t, ts, header, gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("within limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxEncodingTokens(2))
+
+		response := postEncoded(t, ts, "deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+}
+
+func TestLenientDecode(t *testing.T) {
+	t.Parallel()
+
+	sourceData := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog\n"), 1000)
+	encoded := gzipBytes(t, sourceData)
+	truncated := encoded[:len(encoded)/2]
+
+	partialHandler := func(w http.ResponseWriter, r *http.Request) {
 		bodyBytes, err := io.ReadAll(r.Body)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("An error occurred"))
 			return
 		}
+		if DecodeError(r) != nil {
+			w.Header().Set("X-Partial", "true")
+		}
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write(bodyBytes)
 	}
+
+	t.Run("truncated gzip yields partial output", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, partialHandler, LenientDecode(true))
+
+		response := postEncoded(t, ts, "gzip", truncated)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "true", response.Header.Get("X-Partial"))
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		if len(responseBody) == 0 || len(responseBody) >= len(sourceData) {
+			t.Errorf("Expected partial output, got %d of %d bytes", len(responseBody), len(sourceData))
+		}
+		assertEqual(t, sourceData[:len(responseBody)], responseBody)
+	})
+
+	t.Run("complete gzip", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, partialHandler, LenientDecode(true))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "", response.Header.Get("X-Partial"))
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("truncated gzip fails by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, partialHandler)
+
+		response := postEncoded(t, ts, "gzip", truncated)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestOptionsPrecedence(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	base := Options{ContentLengthLimit(10), DisableEncoding("deflate")}
+
+	t.Run("base options", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), base)
+
+		response := postEncoded(t, ts, "", sourceData)
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+
+		response = postEncoded(t, ts, "deflate", deflateBytes(t, []byte("a")))
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("override wins for limits and encodings", func(t *testing.T) {
+		t.Parallel()
+		route := base.With(ContentLengthLimit(1024), SupportEncoding("deflate", DeflateEncodingReader))
+		ts := setupServer(t, echoHandler(), route)
+
+		response := postEncoded(t, ts, "deflate", deflateBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("with doesn't modify the receiver", func(t *testing.T) {
+		t.Parallel()
+		first := base.With(ContentLengthLimit(1))
+		second := base.With(ContentLengthLimit(2))
+
+		assertEqual(t, 2, len(base))
+		var opts options
+		first.apply(&opts)
+		assertEqual(t, int64(1), opts.maxContentLength)
+		second.apply(&opts)
+		assertEqual(t, int64(2), opts.maxContentLength)
+	})
+
+	t.Run("per-request encoding override doesn't affect other requests", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/override" {
+				SetRequestBodyOption(r, DisableEncoding("gzip"))
+			}
+			echoHandler()(w, r)
+		}
+		ts := setupServer(t, handler)
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/override", bytes.NewReader(gzipBytes(t, sourceData)))
+		assertNoError(t, err)
+		req.Header.Set("Content-Encoding", "gzip")
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		response.Body.Close()
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+
+		response = postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestLimitStage(t *testing.T) {
+	t.Parallel()
+
+	// A stored deflate stream is slightly larger than its 10000 byte output, while the
+	// outer gzip compresses it to far fewer bytes.
+	sourceData := make([]byte, 10000)
+	var stored bytes.Buffer
+	deflate, err := flate.NewWriter(&stored, flate.NoCompression)
+	assertNoError(t, err)
+	_, err = deflate.Write(sourceData)
+	assertNoError(t, err)
+	assertNoError(t, deflate.Close())
+	encoded := gzipBytes(t, stored.Bytes())
+	const limit = 10002
+
+	for _, test := range []struct {
+		name     string
+		stage    Option
+		expected int
+	}{
+		{"final output by default", Options{}, http.StatusOK},
+		{"raw body", LimitStage(0), http.StatusOK},
+		{"intermediate deflate stream", LimitStage(1), http.StatusRequestEntityTooLarge},
+		{"final output", LimitStage(2), http.StatusOK},
+		{"beyond the chain", LimitStage(5), http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), ContentLengthLimit(limit), test.stage)
+
+			response := postEncoded(t, ts, "deflate, gzip", encoded)
+
+			assertEqual(t, test.expected, response.StatusCode)
+		})
+	}
+
+	t.Run("intermediate stage over limit returned on read", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+			w.WriteHeader(http.StatusOK)
+		}
+		ts := setupServer(t, handler, ContentLengthLimit(limit), LimitStage(1), ReturnOnError())
+
+		response := postEncoded(t, ts, "deflate, gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		tooLarge, ok := (<-errs).(*RequestContentTooLargeError)
+		assertEqual(t, true, ok)
+		if ok {
+			assertEqual(t, int64(limit), tooLarge.Limit)
+		}
+	})
+}
+
+func TestDecompressedSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	// Zeros compress to a tiny fraction of their size, like a decompression bomb.
+	sourceData := make([]byte, 100000)
+	encoded := gzipBytes(t, sourceData)
+
+	readErr := func(t *testing.T, req *http.Request, opts ...Option) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("decoded body within limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(1000), DecompressedSizeLimit(int64(len(sourceData))))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, len(sourceData), len(readString(t, response)))
+	})
+
+	t.Run("decoded body over limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(-1), DecompressedSizeLimit(50000))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("decoded limit returned on read", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		err := readErr(t, req, ContentLengthLimit(1000), DecompressedSizeLimit(50000))
+
+		assertEqual(t, &RequestContentTooLargeError{Limit: 50000, Read: 50000}, err)
+	})
+
+	t.Run("raw body over content length limit", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+		req.ContentLength = -1
+
+		err := readErr(t, req, ContentLengthLimit(10), DecompressedSizeLimit(int64(len(sourceData))))
+
+		assertEqual(t, &RequestContentTooLargeError{Limit: 10, Read: 10}, err)
+	})
+
+	t.Run("unencoded body over content length limit", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+		req.ContentLength = -1
+
+		err := readErr(t, req, ContentLengthLimit(1000), DecompressedSizeLimit(int64(len(sourceData))))
+
+		assertEqual(t, &RequestContentTooLargeError{Limit: 1000, Read: 1000}, err)
+	})
+
+	t.Run("final stage limits decoded body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		err := readErr(t, req, ContentLengthLimit(1000), LimitStage(1), DecompressedSizeLimit(1<<20))
+
+		assertEqual(t, &RequestContentTooLargeError{Limit: 1000, Read: 1000}, err)
+	})
+
+	t.Run("clamped final stage limits decoded body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		err := readErr(t, req, ContentLengthLimit(1000), LimitStage(5), DecompressedSizeLimit(1<<20))
+
+		assertEqual(t, &RequestContentTooLargeError{Limit: 1000, Read: 1000}, err)
+	})
+
+	t.Run("content length limits decoded body by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(1000))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
+
+func TestDeflateOutputLimit(t *testing.T) {
+	t.Parallel()
+
+	sourceData := make([]byte, 100000)
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+		opts     Options
+		expected int
+	}{
+		{"unlimited by default", "deflate", deflateBytes(t, sourceData), Options{ContentLengthLimit(-1)}, http.StatusOK},
+		{"within deflate limit", "deflate", deflateBytes(t, sourceData), Options{ContentLengthLimit(-1), DeflateOutputLimit(100000)}, http.StatusOK},
+		{"over deflate limit", "deflate", deflateBytes(t, sourceData), Options{ContentLengthLimit(-1), DeflateOutputLimit(1000)}, http.StatusRequestEntityTooLarge},
+		{"outer deflate output within limit", "gzip, deflate", deflateBytes(t, gzipBytes(t, sourceData)), Options{ContentLengthLimit(-1), DeflateOutputLimit(1000)}, http.StatusOK},
+		{"inner deflate over limit", "deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData)), Options{ContentLengthLimit(-1), DeflateOutputLimit(1000)}, http.StatusRequestEntityTooLarge},
+		{"gzip unaffected", "gzip", gzipBytes(t, sourceData), Options{ContentLengthLimit(-1), DeflateOutputLimit(1000)}, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), test.opts)
+
+			response := postEncoded(t, ts, test.encoding, test.encoded)
+
+			assertEqual(t, test.expected, response.StatusCode)
+		})
+	}
+}
+
+func TestBadRequestErrorLayer(t *testing.T) {
+	t.Parallel()
+
+	sourceData := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 100)
+	readErr := func(t *testing.T, encoded []byte) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "deflate, gzip")
+		RequestBodyHandler(http.HandlerFunc(handler), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("corrupt inner layer", func(t *testing.T) {
+		t.Parallel()
+		deflated := deflateBytes(t, sourceData)
+		deflated[0] = 0xff // Reserved block type.
+
+		badRequest, ok := readErr(t, gzipBytes(t, deflated)).(*BadRequestError)
+
+		assertEqual(t, true, ok)
+		if ok {
+			assertEqual(t, 1, badRequest.Layer)
+			assertEqual(t, "deflate", badRequest.Coding)
+		}
+	})
+
+	t.Run("truncated outer layer", func(t *testing.T) {
+		t.Parallel()
+		encoded := gzipBytes(t, deflateBytes(t, sourceData))
+
+		badRequest, ok := readErr(t, encoded[:len(encoded)/2]).(*BadRequestError)
+
+		assertEqual(t, true, ok)
+		if ok {
+			assertEqual(t, 2, badRequest.Layer)
+			assertEqual(t, "gzip", badRequest.Coding)
+		}
+	})
+
+	t.Run("invalid outer header", func(t *testing.T) {
+		t.Parallel()
+
+		badRequest, ok := readErr(t, []byte("not gzip")).(*BadRequestError)
+
+		assertEqual(t, true, ok)
+		if ok {
+			assertEqual(t, 2, badRequest.Layer)
+			assertEqual(t, "gzip", badRequest.Coding)
+		}
+	})
+
+	t.Run("not specific to a layer", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxEncodingTokens(1), HandleRequestBodyError(func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+			badRequest, ok := err.(*BadRequestError)
+			assertEqual(t, true, ok)
+			if ok {
+				assertEqual(t, 0, badRequest.Layer)
+				assertEqual(t, "", badRequest.Coding)
+			}
+			w.WriteHeader(err.RecommendedStatusCode())
+		}))
+
+		response := postEncoded(t, ts, "deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestCaseInsensitiveEncodings(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+		opts     Options
+	}{
+		{"uppercase", "GZIP", gzipBytes(t, sourceData), nil},
+		{"mixed case", "Deflate", deflateBytes(t, sourceData), nil},
+		{"mixed case stacked", "GZIP, Deflate", deflateBytes(t, gzipBytes(t, sourceData)), nil},
+		{"alias", "X-GZip", gzipBytes(t, sourceData), nil},
+		{"registered in uppercase", "ascii85", ascii85Bytes(sourceData), Options{SupportEncoding("ASCII85", Ascii85EncodingReader)}},
+		{"prefix", "VND.Acme.gzip", gzipBytes(t, sourceData), Options{SupportEncodingPrefix("vnd.acme.", GZipEncodingReader)}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), test.opts)
+
+			response := postEncoded(t, ts, test.encoding, test.encoded)
+
+			assertEqual(t, http.StatusOK, response.StatusCode)
+			assertEqual(t, string(sourceData), readString(t, response))
+		})
+	}
+
+	t.Run("disabled in any case", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), DisableEncoding("GZIP"))
+
+		response := postEncoded(t, ts, "Gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("multi frame in any case", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("GZIP", false))
+		encoded := append(gzipBytes(t, sourceData), gzipBytes(t, sourceData)...)
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestEmptyEncodingTokens(t *testing.T) {
+	t.Parallel()
+
+	encoded := deflateBytes(t, gzipBytes(t, []byte("The quick brown fox jumps over the lazy dog")))
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+	}{
+		{"leading comma", ", gzip, deflate"},
+		{"trailing comma", "gzip, deflate,"},
+		{"doubled comma", "gzip,,deflate"},
+		{"blank token", "gzip, , deflate"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			errs := make(chan RequestBodyError, 1)
+			ts := setupServer(t, echoHandler(), HandleRequestBodyError(func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+				errs <- err
+				w.WriteHeader(err.RecommendedStatusCode())
+			}))
+
+			response := postEncoded(t, ts, test.encoding, encoded)
+
+			assertEqual(t, http.StatusBadRequest, response.StatusCode)
+			assertEqual(t, "Bad Request: empty content-coding token", (<-errs).Error())
+		})
+	}
+
+	t.Run("empty body", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan RequestBodyError, 1)
+		ts := setupServer(t, echoHandler(), HandleRequestBodyError(func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+			errs <- err
+			w.WriteHeader(err.RecommendedStatusCode())
+		}))
+
+		response := postEncoded(t, ts, "gzip,,", nil)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+		assertEqual(t, "Bad Request: empty content-coding token", (<-errs).Error())
+	})
+}
+
+func TestMaxEncodingLayers(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	encode := func(layers int) []byte {
+		encoded := sourceData
+		for range layers {
+			encoded = gzipBytes(t, encoded)
+		}
+		return encoded
+	}
+	header := func(layers int) string {
+		return strings.TrimSuffix(strings.Repeat("gzip, ", layers), ", ")
+	}
+
+	t.Run("within default limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, header(3), encode(3))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("over default limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, header(50), encode(50))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("over configured limit", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxEncodingLayers(1))
+
+		response := postEncoded(t, ts, header(2), encode(2))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxEncodingLayers(0))
+
+		response := postEncoded(t, ts, header(10), encode(10))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+}
+
+func TestOnDeprecatedEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+		expected []string
+	}{
+		{"alias", "x-gzip", gzipBytes(t, sourceData), []string{"x-gzip"}},
+		{"alias in any case", "X-GZIP", gzipBytes(t, sourceData), []string{"x-gzip"}},
+		{"standard coding", "gzip", gzipBytes(t, sourceData), nil},
+		{"stacked", "deflate, x-gzip", gzipBytes(t, deflateBytes(t, sourceData)), []string{"x-gzip"}},
+		{"compress", "compress", deflateBytes(t, sourceData), []string{"compress"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			var deprecated []string
+			callback := func(r *http.Request, name string) {
+				if _, ok := RequestBodyInfo(r); !ok {
+					t.Errorf("Expected the callback to receive the wrapped request")
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				deprecated = append(deprecated, name)
+			}
+			ts := setupServer(t, echoHandler(), OnDeprecatedEncoding(callback), SupportEncoding("compress", DeflateEncodingReader))
+
+			response := postEncoded(t, ts, test.encoding, test.encoded)
+
+			assertEqual(t, http.StatusOK, response.StatusCode)
+			mu.Lock()
+			defer mu.Unlock()
+			assertEqual(t, test.expected, deprecated)
+		})
+	}
+}
+
+func TestOnBodyComplete(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	type completion struct {
+		bytesRead int64
+		encoding  string
+	}
+	completeHandler := func(completions chan<- completion, opts ...Option) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, append(opts, OnBodyComplete(func(r *http.Request, bytesRead int64, encoding string) {
+				completions <- completion{bytesRead, encoding}
+			}))...)
+			_, _ = io.ReadAll(r.Body)
+			// Reading again after EOF doesn't invoke the callback again.
+			_, _ = r.Body.Read(make([]byte, 1))
+			close(completions)
+		}
+	}
+	collect := func(completions <-chan completion) []completion {
+		var collected []completion
+		for c := range completions {
+			collected = append(collected, c)
+		}
+		return collected
+	}
+
+	t.Run("encoded body", func(t *testing.T) {
+		t.Parallel()
+		completions := make(chan completion, 2)
+		ts := setupServer(t, completeHandler(completions))
+
+		response := postEncoded(t, ts, "deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, []completion{{int64(len(sourceData)), "deflate, gzip"}}, collect(completions))
+	})
+
+	t.Run("zero-length body", func(t *testing.T) {
+		t.Parallel()
+		completions := make(chan completion, 2)
+		ts := setupServer(t, completeHandler(completions))
+
+		response := postEncoded(t, ts, "", nil)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, []completion{{0, ""}}, collect(completions))
+	})
+
+	t.Run("not invoked on error", func(t *testing.T) {
+		t.Parallel()
+		completions := make(chan completion, 2)
+		ts := setupServer(t, completeHandler(completions, ReturnOnError()))
+
+		response := postEncoded(t, ts, "gzip", []byte("not gzip"))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, []completion(nil), collect(completions))
+	})
+
+	t.Run("not invoked when decoding ends early", func(t *testing.T) {
+		t.Parallel()
+		completions := make(chan completion, 2)
+		ts := setupServer(t, completeHandler(completions, LenientDecode(true)))
+		encoded := gzipBytes(t, bytes.Repeat(sourceData, 100))
+
+		response := postEncoded(t, ts, "gzip", encoded[:len(encoded)/2])
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, []completion(nil), collect(completions))
+	})
+
+	t.Run("accessors within callback", func(t *testing.T) {
+		t.Parallel()
+		type accessors struct {
+			bytesRead int64
+			infoOK    bool
+			encodings []string
+		}
+		results := make(chan accessors, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+		}
+		callback := func(r *http.Request, bytesRead int64, encoding string) {
+			_, infoOK := RequestBodyInfo(r)
+			results <- accessors{BytesRead(r), infoOK, AppliedEncodings(r)}
+		}
+		ts := setupServer(t, handler, OnBodyComplete(callback))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, accessors{int64(len(sourceData)), true, []string{"gzip"}}, <-results)
+	})
+}
+
+func TestAsRequestBodyError(t *testing.T) {
+	t.Parallel()
+
+	tooLarge := &RequestContentTooLargeError{Limit: 10}
+	for _, test := range []struct {
+		name     string
+		err      error
+		expected RequestBodyError
+	}{
+		{"direct", tooLarge, tooLarge},
+		{"wrapped", fmt.Errorf("reading upload: %w", tooLarge), tooLarge},
+		{"wrapped twice", fmt.Errorf("handler: %w", fmt.Errorf("reading upload: %w", tooLarge)), tooLarge},
+		{"other error", io.ErrUnexpectedEOF, nil},
+		{"nil", nil, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			bodyErr, ok := AsRequestBodyError(test.err)
+
+			assertEqual(t, test.expected != nil, ok)
+			assertEqual(t, test.expected, bodyErr)
+		})
+	}
+
+	t.Run("returned on read", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			if rbe, ok := AsRequestBodyError(fmt.Errorf("reading body: %w", err)); ok {
+				w.WriteHeader(rbe.RecommendedStatusCode())
+			}
+		}
+		ts := setupServer(t, handler, ReturnOnError())
+
+		response := postEncoded(t, ts, "compress", []byte("data"))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+}
+
+func TestDecodeTransferEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, body []byte, contentEncoding string, transferEncoding []string, opts ...Option) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.ContentLength = -1
+		req.TransferEncoding = transferEncoding
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), opts...).ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("chunked and gzip", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, gzipBytes(t, sourceData), "", []string{"gzip", "chunked"}, DecodeTransferEncoding(true))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("combined with content encoding", func(t *testing.T) {
+		t.Parallel()
+		encoded := gzipBytes(t, deflateBytes(t, sourceData))
+
+		response := serve(t, encoded, "deflate", []string{"gzip", "chunked"}, DecodeTransferEncoding(true))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("chunked only", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, sourceData, "", []string{"chunked"}, DecodeTransferEncoding(true))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("unsupported transfer coding", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, sourceData, "", []string{"compress", "chunked"}, DecodeTransferEncoding(true))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.Code)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		encoded := gzipBytes(t, sourceData)
+
+		response := serve(t, encoded, "", []string{"gzip", "chunked"})
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(encoded), response.Body.String())
+	})
+}
+
+func TestEncodingParameters(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+	}{
+		{"quality value", "gzip;q=1.0", gzipBytes(t, sourceData)},
+		{"spaced quality value", "gzip ; q=0.5", gzipBytes(t, sourceData)},
+		{"stacked", "deflate;q=1, gzip;q=0", gzipBytes(t, deflateBytes(t, sourceData))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler())
+
+			response := postEncoded(t, ts, test.encoding, test.encoded)
+
+			assertEqual(t, http.StatusOK, response.StatusCode)
+			assertEqual(t, string(sourceData), readString(t, response))
+		})
+	}
+
+	t.Run("parameters without a coding", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "gzip, ;q=1", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestStrictEncodingParsing(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+		strict   int
+		lenient  int
+	}{
+		{"quality value", "gzip;q=1.0", gzipBytes(t, sourceData), http.StatusBadRequest, http.StatusOK},
+		{"spaced quality value", "gzip ; q=1", gzipBytes(t, sourceData), http.StatusBadRequest, http.StatusOK},
+		{"trailing semicolon", "gzip;", gzipBytes(t, sourceData), http.StatusBadRequest, http.StatusOK},
+		{"second coding", "deflate, gzip ;q=1", gzipBytes(t, deflateBytes(t, sourceData)), http.StatusBadRequest, http.StatusOK},
+		{"without parameters", " deflate , gzip ", gzipBytes(t, deflateBytes(t, sourceData)), http.StatusOK, http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			strict := setupServer(t, echoHandler(), StrictEncodingParsing(true))
+			lenient := setupServer(t, echoHandler(), StrictEncodingParsing(false))
+
+			strictResponse := postEncoded(t, strict, test.encoding, test.encoded)
+			lenientResponse := postEncoded(t, lenient, test.encoding, test.encoded)
+
+			assertEqual(t, test.strict, strictResponse.StatusCode)
+			assertEqual(t, test.lenient, lenientResponse.StatusCode)
+			assertEqual(t, string(sourceData), readString(t, lenientResponse))
+		})
+	}
+
+	t.Run("error message", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData)))
+		req.Header.Set("Content-Encoding", "gzip ; q=1")
+		RequestBodyHandler(http.HandlerFunc(handler), StrictEncodingParsing(true), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+		var badRequest *BadRequestError
+		err := <-errs
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, `Bad Request: content-coding "gzip ; q=1" has parameters`, err.Error())
+	})
+}
+
+func TestEncodingLimit(t *testing.T) {
+	t.Parallel()
+
+	sourceData := make([]byte, 10000)
+	readErr := func(t *testing.T, contentEncoding string, body []byte, opts ...Option) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if contentEncoding != "" {
+			req.Header.Set("Content-Encoding", contentEncoding)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+	limits := Options{ContentLengthLimit(20000), EncodingLimit("gzip", 1000), EncodingLimit("deflate", 5000)}
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+		opts     Options
+		expected error
+	}{
+		{"encoding limit", "gzip", gzipBytes(t, sourceData), limits, &RequestContentTooLargeError{Limit: 1000, Read: 1000}},
+		{"encoding limit larger than global", "gzip", gzipBytes(t, sourceData), Options{ContentLengthLimit(100), EncodingLimit("gzip", 20000)}, nil},
+		{"other encoding uses global limit", "x-custom", gzipBytes(t, sourceData), Options{limits, SupportEncoding("x-custom", GZipEncodingReader)}, nil},
+		{"most restrictive when stacked", "deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData)), limits, &RequestContentTooLargeError{Limit: 1000, Read: 1000}},
+		{"case-insensitive", "GZIP", gzipBytes(t, sourceData), Options{EncodingLimit("Gzip", 1000)}, &RequestContentTooLargeError{Limit: 1000, Read: 1000}},
+		{"identity", "", sourceData, Options{EncodingLimit("identity", 2000)}, &RequestContentTooLargeError{Limit: 2000, Read: 2000}},
+		{"removed override", "gzip", gzipBytes(t, sourceData), Options{limits, EncodingLimit("gzip", 0)}, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := readErr(t, test.encoding, test.encoded, test.opts)
+
+			assertEqual(t, test.expected, err)
+		})
+	}
+
+	t.Run("response status", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), limits)
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+}
+
+func TestIdentityEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+		encoded  []byte
+	}{
+		{"identity", "identity", sourceData},
+		{"identity stacked first", "identity, gzip", gzipBytes(t, sourceData)},
+		{"identity stacked last", "gzip, identity", gzipBytes(t, sourceData)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			ts := setupServer(t, echoHandler(), MinCompressionRatio(0.5))
+
+			response := postEncoded(t, ts, test.encoding, test.encoded)
+
+			assertEqual(t, http.StatusOK, response.StatusCode)
+			assertEqual(t, string(sourceData), readString(t, response))
+		})
+	}
+
+	t.Run("not advertised", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, false, strings.Contains(response.Header.Get("Accept-Encoding"), "identity"))
+	})
+
+	t.Run("not deprecated", func(t *testing.T) {
+		t.Parallel()
+		var deprecated atomic.Bool
+		ts := setupServer(t, echoHandler(), OnDeprecatedEncoding(func(r *http.Request, name string) {
+			deprecated.Store(true)
+		}))
+
+		response := postEncoded(t, ts, "identity", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, false, deprecated.Load())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), DisableEncoding("identity"))
+
+		response := postEncoded(t, ts, "identity", sourceData)
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+}
+
+func TestBlankContentEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	for _, test := range []struct {
+		name     string
+		encoding string
+	}{
+		{"empty", ""},
+		{"spaces", "   "},
+		{"tab", "\t"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+			req.Header["Content-Encoding"] = []string{test.encoding}
+			response := httptest.NewRecorder()
+
+			RequestBodyHandler(http.HandlerFunc(echoHandler())).ServeHTTP(response, req)
+
+			assertEqual(t, http.StatusOK, response.Code)
+			assertEqual(t, string(sourceData), response.Body.String())
+		})
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("canceled mid-read", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		errs := make(chan error, 2)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			buf := make([]byte, 4)
+			_, err := r.Body.Read(buf)
+			errs <- err
+			cancel()
+			_, err = r.Body.Read(buf)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData))).WithContext(ctx)
+		req.Header.Set("Content-Encoding", "gzip")
+		RequestBodyHandler(http.HandlerFunc(handler), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+		assertNoError(t, <-errs)
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(<-errs, &badRequest))
+		assertEqual(t, context.Canceled, badRequest.Err)
+	})
+
+	t.Run("error handler", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		handled := make(chan error, 1)
+		errorHandler := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+			handled <- err
+			w.WriteHeader(err.RecommendedStatusCode())
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData)).WithContext(ctx)
+		recorder := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), HandleRequestBodyError(errorHandler)).ServeHTTP(recorder, req)
+
+		assertEqual(t, http.StatusBadRequest, recorder.Code)
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(<-handled, &badRequest))
+		assertEqual(t, context.Canceled, badRequest.Err)
+	})
+}
+
+func TestUnsupportedMediaTypeErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, req *http.Request, opts ...Option) *RequestUnsupportedMediaTypeError {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		var unsupported *RequestUnsupportedMediaTypeError
+		if err := <-errs; !errors.As(err, &unsupported) {
+			t.Fatalf("Expected RequestUnsupportedMediaTypeError, got %v", err)
+		}
+		return unsupported
+	}
+
+	t.Run("content encoding", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.Header.Set("Content-Encoding", "gzip, compress")
+
+		unsupported := serve(t, req)
+
+		assertEqual(t, "Content-Encoding", unsupported.Header)
+		assertEqual(t, "compress", unsupported.Value)
+		assertEqual(t, "Unsupported Media Type: compress", unsupported.Error())
+	})
+
+	t.Run("transfer encoding", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.ContentLength = -1
+		req.TransferEncoding = []string{"compress", "chunked"}
+
+		unsupported := serve(t, req, DecodeTransferEncoding(true))
+
+		assertEqual(t, "Transfer-Encoding", unsupported.Header)
+		assertEqual(t, "compress", unsupported.Value)
+	})
+
+	t.Run("content type", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.Header.Set("Content-Type", "text/plain")
+
+		unsupported := serve(t, req, AllowContentTypes("application/json"))
+
+		assertEqual(t, "Content-Type", unsupported.Header)
+		assertEqual(t, "text/plain", unsupported.Value)
+		assertEqual(t, "Unsupported Media Type: text/plain", unsupported.Error())
+	})
+}
+
+// readSizeRecorder records the largest buffer passed to Read.
+type readSizeRecorder struct {
+	reader  io.Reader
+	maxRead int
+}
+
+func (r *readSizeRecorder) Read(p []byte) (int, error) {
+	r.maxRead = max(r.maxRead, len(p))
+	return r.reader.Read(p)
+}
+
+func TestDecoderReadChunk(t *testing.T) {
+	t.Parallel()
+
+	sourceData := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 1000)
+	serve := func(t *testing.T, encoding string, body []byte, opts ...Option) (*httptest.ResponseRecorder, int) {
+		t.Helper()
+		recorder := &readSizeRecorder{reader: bytes.NewReader(body)}
+		req := httptest.NewRequest(http.MethodPost, "/", recorder)
+		req.Header.Set("Content-Encoding", encoding)
+		response := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), opts...).ServeHTTP(response, req)
+		return response, recorder.maxRead
+	}
+
+	for _, tc := range []struct {
+		encoding string
+		body     []byte
+	}{
+		{"gzip", gzipBytes(t, sourceData)},
+		{"deflate", deflateBytes(t, sourceData)},
+		{"deflate, gzip", gzipBytes(t, deflateBytes(t, sourceData))},
+	} {
+		t.Run(tc.encoding, func(t *testing.T) {
+			t.Parallel()
+
+			response, maxRead := serve(t, tc.encoding, tc.body, DecoderReadChunk(64))
+
+			assertEqual(t, http.StatusOK, response.Code)
+			assertEqual(t, string(sourceData), response.Body.String())
+			if maxRead > 64 {
+				t.Errorf("Expected reads of at most 64 bytes, got %d", maxRead)
+			}
+		})
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		response, maxRead := serve(t, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		if maxRead <= 64 {
+			t.Errorf("Expected reads larger than 64 bytes, got %d", maxRead)
+		}
+	})
+}
+
+func BenchmarkDecoderReadChunk(b *testing.B) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, _ = gz.Write(bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog"), 10000))
+	_ = gz.Close()
+	body := buf.Bytes()
+	for _, chunk := range []int{0, 512, 4096} {
+		b.Run(fmt.Sprintf("chunk %d", chunk), func(b *testing.B) {
+			handler := RequestBodyHandler(http.HandlerFunc(echoHandler()), DecoderReadChunk(chunk))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+				req.Header.Set("Content-Encoding", "gzip")
+				handler.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		})
+	}
+}
+
+func TestDeclaredVsActualTolerance(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, body []byte, declared int64, encoding string, opts ...Option) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.ContentLength = declared
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("over-declared length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, 10_000_000, "", DeclaredVsActualTolerance(0.1))
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, "Bad Request: read 43 bytes of the declared content length 10000000", err.Error())
+	})
+
+	t.Run("over-declared encoded length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, gzipBytes(t, sourceData), 10_000_000, "gzip", DeclaredVsActualTolerance(0.1))
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+	})
+
+	t.Run("within tolerance", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, int64(len(sourceData))+4, "", DeclaredVsActualTolerance(0.1))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("exact length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, int64(len(sourceData)), "", DeclaredVsActualTolerance(0.01))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, -1, "", DeclaredVsActualTolerance(0.1))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, 10_000_000, "")
+
+		assertNoError(t, err)
+	})
+}
+
+func TestEnforceContentLengthMatch(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, body []byte, declared int64, encoding string, opts ...Option) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.ContentLength = declared
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("fewer bytes than declared", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, 50, "", EnforceContentLengthMatch(true))
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, "Bad Request: read 43 bytes but the declared content length is 50", err.Error())
+	})
+
+	t.Run("more bytes than declared", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, 40, "", EnforceContentLengthMatch(true))
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, "Bad Request: read 43 bytes but the declared content length is 40", err.Error())
+	})
+
+	t.Run("matching length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, int64(len(sourceData)), "identity", EnforceContentLengthMatch(true))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, -1, "", EnforceContentLengthMatch(true))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("encoded bodies aren't checked", func(t *testing.T) {
+		t.Parallel()
+		compressed := gzipBytes(t, sourceData)
+
+		err := serve(t, compressed, int64(len(compressed))+10, "gzip", EnforceContentLengthMatch(true))
+
+		assertNoError(t, err)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, sourceData, 50, "")
+
+		assertNoError(t, err)
+	})
+}
+
+func TestAdvertiseAcceptEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(handler http.HandlerFunc, encoding string, opts ...Option) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandler(handler, opts...).ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("successful response", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(echoHandler(), "", AdvertiseAcceptEncoding(true))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header().Get("Accept-Encoding"))
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(echoHandler(), "compress", AdvertiseAcceptEncoding(true))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.Code)
+		assertEqual(t, strings.Join(defaultEncodings(), ", "), response.Header().Get("Accept-Encoding"))
+	})
+
+	t.Run("unsupported encoding with per-request override", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, DisableEncoding("br"))
+			echoHandler()(w, r)
+		}
+
+		response := serve(handler, "br", AdvertiseAcceptEncoding(true))
+
+		withoutBrotli := slices.DeleteFunc(defaultEncodings(), func(coding string) bool { return coding == "br" })
+		assertEqual(t, http.StatusUnsupportedMediaType, response.Code)
+		assertEqual(t, strings.Join(withoutBrotli, ", "), response.Header().Get("Accept-Encoding"))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(echoHandler(), "compress")
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.Code)
+		assertEqual(t, "", response.Header().Get("Accept-Encoding"))
+	})
+}
+
+// TestSetDefaultErrorHandler isn't parallel as it changes the package default.
+func TestSetDefaultErrorHandler(t *testing.T) {
+	t.Cleanup(func() { SetDefaultErrorHandler(nil) })
+
+	teapot := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+	serve := func(handler http.Handler) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.Header.Set("Content-Encoding", "compress")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	before := RequestBodyHandler(http.HandlerFunc(echoHandler()))
+	SetDefaultErrorHandler(teapot)
+	after := RequestBodyHandler(http.HandlerFunc(echoHandler()))
+	overridden := RequestBodyHandler(http.HandlerFunc(echoHandler()), HandleRequestBodyError(StatusOnlyRequestBodyErrorHandler))
+
+	assertEqual(t, http.StatusUnsupportedMediaType, serve(before))
+	assertEqual(t, http.StatusTeapot, serve(after))
+	assertEqual(t, http.StatusUnsupportedMediaType, serve(overridden))
+
+	SetDefaultErrorHandler(nil)
+	assertEqual(t, http.StatusUnsupportedMediaType, serve(RequestBodyHandler(http.HandlerFunc(echoHandler()))))
+}
+
+func TestMultipleContentEncodingHeaders(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, body []byte, encodings ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		for _, encoding := range encodings {
+			req.Header.Add("Content-Encoding", encoding)
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler())).ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("layered decoding", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, gzipBytes(t, deflateBytes(t, sourceData)), "deflate", "gzip")
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("combined with a list", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, gzipBytes(t, gzipBytes(t, deflateBytes(t, sourceData))), "deflate, gzip", "gzip")
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("blank header ignored", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, gzipBytes(t, sourceData), "gzip", " ")
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("unsupported second header", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, gzipBytes(t, sourceData), "gzip", "compress")
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.Code)
+	})
+
+	t.Run("from client", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(gzipBytes(t, deflateBytes(t, sourceData))))
+		assertNoError(t, err)
+		req.Header.Add("Content-Encoding", "deflate")
+		req.Header.Add("Content-Encoding", "gzip")
+
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+}
+
+func TestNilEncodingReader(t *testing.T) {
+	t.Parallel()
+
+	nilReader := func(r io.Reader) (io.ReadCloser, error) {
+		return nil, nil
+	}
+	errs := make(chan error, 1)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		errs <- err
+	}
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+	req.Header.Set("Content-Encoding", "broken")
+	RequestBodyHandler(http.HandlerFunc(handler), SupportEncoding("broken", nilReader), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+	var badRequest *BadRequestError
+	err := <-errs
+	assertEqual(t, true, errors.As(err, &badRequest))
+	assertEqual(t, "broken", badRequest.Coding)
+	assertEqual(t, "Bad Request: failed to create encoding reader for broken: encoding reader returned a nil reader", err.Error())
+
+	t.Run("status code", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncoding("broken", nilReader))
+
+		response := postEncoded(t, ts, "broken", []byte("data"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestTrustContentLength(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, body []byte, declared int64, encoding string, opts ...Option) (*lazyReader, error) {
+		t.Helper()
+		type result struct {
+			body *lazyReader
+			err  error
+		}
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			body, _ := bodyFromRequest(r)
+			results <- result{body, err}
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.ContentLength = declared
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		got := <-results
+		return got.body, got.err
+	}
+	// limited reports whether the body is wrapped to enforce the limit while reading.
+	limited := func(body *lazyReader) bool {
+		return body.reader != body.chain
+	}
+
+	t.Run("declared length within limit", func(t *testing.T) {
+		t.Parallel()
+
+		body, err := serve(t, sourceData, int64(len(sourceData)), "", ContentLengthLimit(100), TrustContentLength(true))
+
+		assertNoError(t, err)
+		assertEqual(t, false, limited(body))
+	})
+
+	t.Run("declared length over limit", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := serve(t, sourceData, int64(len(sourceData)), "", ContentLengthLimit(10), TrustContentLength(true))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(err, &tooLarge))
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		t.Parallel()
+
+		body, err := serve(t, sourceData, -1, "", ContentLengthLimit(10), TrustContentLength(true))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(err, &tooLarge))
+		assertEqual(t, true, limited(body))
+	})
+
+	t.Run("encoded body", func(t *testing.T) {
+		t.Parallel()
+		// A tiny compressed body can decode to far more than its declared length.
+		encoded := gzipBytes(t, make([]byte, 10000))
+
+		body, err := serve(t, encoded, int64(len(encoded)), "gzip", ContentLengthLimit(1000), TrustContentLength(true))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(err, &tooLarge))
+		assertEqual(t, true, limited(body))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		body, err := serve(t, sourceData, int64(len(sourceData)), "", ContentLengthLimit(100))
+
+		assertNoError(t, err)
+		assertEqual(t, true, limited(body))
+	})
+}
+
+func TestRejectMalformedContentLength(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, header string, opts ...Option) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.ContentLength = -1
+		if header != "" {
+			req.Header.Set("Content-Length", header)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, "abc", RequireContentLength(true), RejectMalformedContentLength(true))
+
+		var malformed *MalformedContentLengthError
+		assertEqual(t, true, errors.As(err, &malformed))
+		assertEqual(t, "abc", malformed.Value)
+		assertEqual(t, http.StatusBadRequest, malformed.RecommendedStatusCode())
+	})
+
+	t.Run("negative", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, "-5", RequireContentLength(true), RejectMalformedContentLength(true))
+
+		var malformed *MalformedContentLengthError
+		assertEqual(t, true, errors.As(err, &malformed))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, "", RequireContentLength(true), RejectMalformedContentLength(true))
+
+		var required *RequestContentLengthRequiredError
+		assertEqual(t, true, errors.As(err, &required))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, "abc", RequireContentLength(true))
+
+		var required *RequestContentLengthRequiredError
+		assertEqual(t, true, errors.As(err, &required))
+	})
+
+	t.Run("length not required", func(t *testing.T) {
+		t.Parallel()
+
+		err := serve(t, "abc", RejectMalformedContentLength(true))
+
+		assertNoError(t, err)
+	})
+}
+
+func TestHandleErrorKind(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	teapotHandler := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		w.WriteHeader(http.StatusTeapot)
+	}
+
+	t.Run("matching kind", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), HandleErrorKind(reflect.TypeOf(&BadRequestError{}), teapotHandler))
+
+		response := postEncoded(t, ts, "gzip", []byte("not gzip"))
+
+		assertEqual(t, http.StatusTeapot, response.StatusCode)
+	})
+
+	t.Run("falls back to global handler", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(10), HandleErrorKind(reflect.TypeOf(&BadRequestError{}), teapotHandler))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("interface kind", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(10), HandleErrorKind(reflect.TypeFor[RequestBodyError](), teapotHandler))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusTeapot, response.StatusCode)
+	})
+
+	t.Run("exact type preferred over interface", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(10),
+			HandleErrorKind(reflect.TypeFor[RequestBodyError](), teapotHandler),
+			HandleErrorOf(func(w http.ResponseWriter, r *http.Request, err *RequestContentTooLargeError) {
+				w.WriteHeader(http.StatusInsufficientStorage)
+				_, _ = fmt.Fprint(w, err.Limit)
+			}),
+		)
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusInsufficientStorage, response.StatusCode)
+		assertEqual(t, "10", readString(t, response))
+	})
+
+	t.Run("with return on error", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if _, err := io.ReadAll(r.Body); err != nil {
+				w.WriteHeader(http.StatusAccepted)
+			}
+		}
+		ts := setupServer(t, handler, ReturnOnError(), ContentLengthLimit(10), HandleErrorKind(reflect.TypeOf(&BadRequestError{}), teapotHandler))
+
+		badRequest := postEncoded(t, ts, "gzip", []byte("not gzip"))
+		tooLarge := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusTeapot, badRequest.StatusCode)
+		assertEqual(t, http.StatusAccepted, tooLarge.StatusCode)
+	})
+
+	t.Run("removed per request", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(HandleErrorKind(reflect.TypeOf(&BadRequestError{}), nil)),
+			HandleErrorKind(reflect.TypeOf(&BadRequestError{}), teapotHandler))
+
+		response := postEncoded(t, ts, "gzip", []byte("not gzip"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("not applied by read all", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ReadAll(r); err != nil {
+				w.WriteHeader(http.StatusAccepted)
+			}
+		}
+		ts := setupServer(t, handler, HandleErrorKind(reflect.TypeOf(&BadRequestError{}), teapotHandler))
+
+		response := postEncoded(t, ts, "gzip", []byte("not gzip"))
+
+		assertEqual(t, http.StatusAccepted, response.StatusCode)
+	})
+
+	t.Run("invalid kind", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			assertEqual(t, true, recover() != nil)
+		}()
+
+		HandleErrorKind(reflect.TypeOf(errors.New("")), teapotHandler)
+	})
+}
+
+func TestLimitFromHeader(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	trusted := func(r *http.Request) bool {
+		return r.Header.Get("X-Gateway") == "trusted"
+	}
+	serve := func(t *testing.T, override string, gateway string) *http.Response {
+		t.Helper()
+		ts := setupServer(t, echoHandler(), ContentLengthLimit(10), LimitFromHeader("X-Max-Body-Override", trusted))
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(sourceData))
+		assertNoError(t, err)
+		if override != "" {
+			req.Header.Set("X-Max-Body-Override", override)
+		}
+		if gateway != "" {
+			req.Header.Set("X-Gateway", gateway)
+		}
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, "100", "trusted")
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("trusted lower limit", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, "5", "trusted")
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, "100", "")
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		t.Parallel()
+
+		for _, value := range []string{"lots", "-1", "1e6"} {
+			response := serve(t, value, "trusted")
+
+			assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+		}
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		t.Parallel()
+
+		response := serve(t, "", "trusted")
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("requires validate", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			assertEqual(t, true, recover() != nil)
+		}()
+
+		LimitFromHeader("X-Max-Body-Override", nil)
+	})
+}
+
+func TestEmptyBodyEncodings(t *testing.T) {
+	t.Parallel()
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "bogus", nil)
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("unsupported after supported encoding", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "gzip, bogus", nil)
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("supported encoding", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "gzip", nil)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, "", readString(t, response))
+	})
+
+	t.Run("error details", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		ts := setupServer(t, handler, ReturnOnError())
+
+		postEncoded(t, ts, "Bogus", nil)
+
+		var unsupported *RequestUnsupportedMediaTypeError
+		assertEqual(t, true, errors.As(<-errs, &unsupported))
+		assertEqual(t, "Bogus", unsupported.Encoding)
+		assertEqual(t, "Content-Encoding", unsupported.Header)
+	})
+}
+
+func TestRejectChunked(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, transferEncoding []string, contentLength int64, opts ...Option) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("data"))
+		req.TransferEncoding = transferEncoding
+		req.ContentLength = contentLength
+		response := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), opts...).ServeHTTP(response, req)
+		return response.Code
+	}
+
+	t.Run("chunked", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusLengthRequired, serve(t, []string{"chunked"}, -1, RejectChunked(true)))
+	})
+
+	t.Run("chunked with known length", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusLengthRequired, serve(t, []string{"Chunked"}, 4, RejectChunked(true)))
+	})
+
+	t.Run("overridden status", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusBadRequest, serve(t, []string{"chunked"}, -1, RejectChunked(true), LengthRequiredStatus(http.StatusBadRequest)))
+	})
+
+	t.Run("unknown length without chunked", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusOK, serve(t, nil, -1, RejectChunked(true)))
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusOK, serve(t, []string{"chunked"}, -1))
+	})
+}
+
+func TestCloseAfterHandledError(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	var handled atomic.Int32
+	closeErrs := make(chan error, 1)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			// Send even if Close panics, so the test fails rather than hangs.
+			closeErr := errors.New("close panicked")
+			defer func() {
+				closeErrs <- closeErr
+			}()
+			closeErr = r.Body.Close()
+		}()
+		_, _ = io.ReadAll(r.Body)
+	}
+	errorHandler := func(w http.ResponseWriter, r *http.Request, err RequestBodyError) {
+		handled.Add(1)
+		StatusOnlyRequestBodyErrorHandler(w, r, err)
+	}
+	ts := setupServer(t, handler, ContentLengthLimit(10), HandleRequestBodyError(errorHandler))
+
+	response := postEncoded(t, ts, "", sourceData)
+
+	assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	var tooLarge *RequestContentTooLargeError
+	assertEqual(t, true, errors.As(<-closeErrs, &tooLarge))
+	assertEqual(t, int32(1), handled.Load())
+}
+
+func TestSupportEncodingFunc(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	// xorReader decodes a body XORed with the key from the request's X-Key header.
+	xorReader := func(input io.Reader, req *http.Request) (io.ReadCloser, error) {
+		key := req.Header.Get("X-Key")
+		if key == "" {
+			return nil, errors.New("missing key")
+		}
+		data, err := io.ReadAll(input)
+		if err != nil {
+			return nil, err
+		}
+		for i := range data {
+			data[i] ^= key[i%len(key)]
+		}
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	xor := func(data []byte, key string) []byte {
+		encoded := bytes.Clone(data)
+		for i := range encoded {
+			encoded[i] ^= key[i%len(key)]
+		}
+		return encoded
+	}
+	post := func(t *testing.T, ts *httptest.Server, encoding, key string, body []byte) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+		assertNoError(t, err)
+		req.Header.Set("Content-Encoding", encoding)
+		if key != "" {
+			req.Header.Set("X-Key", key)
+		}
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	t.Run("receives request", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingFunc("x-xor", xorReader))
+
+		response := post(t, ts, "x-xor", "secret", xor(sourceData, "secret"))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("chained with gzip", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingFunc("X-Xor", xorReader))
+
+		response := post(t, ts, "x-xor, gzip", "secret", gzipBytes(t, xor(sourceData, "secret")))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("construction error", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingFunc("x-xor", xorReader))
+
+		response := post(t, ts, "x-xor", "", xor(sourceData, "secret"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("advertised", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingFunc("x-xor", xorReader))
+		req, err := http.NewRequest(http.MethodOptions, ts.URL, nil)
+		assertNoError(t, err)
+
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, strings.Join(defaultEncodings("x-xor"), ", "), response.Header.Get("Accept-Encoding"))
+	})
+}
+
+func TestLateOptions(t *testing.T) {
+	t.Parallel()
+
+	// serve reads the first byte of the body before calling the handler, like an earlier middleware would.
+	serve := func(t *testing.T, readFirst bool, handler http.HandlerFunc, opts ...Option) *httptest.ResponseRecorder {
+		t.Helper()
+		var h http.Handler = handler
+		if readFirst {
+			h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = r.Body.Read(make([]byte, 1))
+				handler(w, r)
+			})
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello world"))
+		req.ContentLength = -1 // Streamed, so the limit is only checked while reading.
+		recorder := httptest.NewRecorder()
+		RequestBodyHandler(h, opts...).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	t.Run("options set before reading aren't late", func(t *testing.T) {
+		t.Parallel()
+		late := 0
+		recorder := serve(t, false, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, RequireContentLength(true))
+			_, err := io.ReadAll(r.Body)
+			assertNoError(t, err)
+		}, OnLateOptions(func(r *http.Request) { late++ }))
+		assertEqual(t, http.StatusLengthRequired, recorder.Code)
+		assertEqual(t, 0, late)
+	})
+
+	t.Run("content length limit still applies after reading", func(t *testing.T) {
+		t.Parallel()
+		late := 0
+		var read []byte
+		recorder := serve(t, true, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, ContentLengthLimit(-1), Options{ContentLengthLimit(-1)})
+			var err error
+			read, err = io.ReadAll(r.Body)
+			assertNoError(t, err)
+		}, ContentLengthLimit(5), OnLateOptions(func(r *http.Request) { late++ }))
+		assertEqual(t, http.StatusOK, recorder.Code)
+		assertEqual(t, "ello world", string(read))
+		assertEqual(t, 0, late)
+	})
+
+	t.Run("other options after reading are late", func(t *testing.T) {
+		t.Parallel()
+		late := 0
+		serve(t, true, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, ContentLengthLimit(-1), RequireContentLength(true))
+			SetRequestBodyOption(r, Options{ContentLengthLimit(-1), RequireContentLength(true)})
+		}, OnLateOptions(func(r *http.Request) { late++ }))
+		assertEqual(t, 2, late)
+	})
+
+	t.Run("late options can panic", func(t *testing.T) {
+		t.Parallel()
+		defer func() {
+			if v := recover(); v != "late options" {
+				t.Fatalf("expected late options panic, got %v", v)
+			}
+		}()
+		serve(t, true, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, RequireContentLength(true))
+		}, OnLateOptions(func(r *http.Request) { panic("late options") }))
+	})
+
+	t.Run("ignores nil options and requests without the middleware", func(t *testing.T) {
+		t.Parallel()
+		SetRequestBodyOption(nil, RequireContentLength(true))
+		SetRequestBodyOption(httptest.NewRequest(http.MethodPost, "/", nil), RequireContentLength(true))
+		recorder := serve(t, true, func(w http.ResponseWriter, r *http.Request) {
+			SetRequestBodyOption(r, nil, Options{nil})
+		}, OnLateOptions(func(r *http.Request) { t.Error("unexpected late options") }))
+		assertEqual(t, http.StatusOK, recorder.Code)
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	gzipped := gzipBytes(t, sourceData)
+	deflated := deflateBytes(t, sourceData)
+	// Setting the reserved block type makes the stream invalid from the first block.
+	corruptDeflate := append([]byte{0x07}, deflated[1:]...)
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		message  string
+		is       error
+	}{
+		{"malformed gzip header", "gzip", []byte("this is not gzip data"), "Bad Request: malformed gzip header: gzip: invalid header", gzip.ErrHeader},
+		{"truncated gzip header", "gzip", gzipped[:5], "Bad Request: truncated gzip stream: unexpected EOF", io.ErrUnexpectedEOF},
+		{"truncated gzip stream", "gzip", gzipped[:len(gzipped)/2], "Bad Request: truncated gzip stream: unexpected EOF", io.ErrUnexpectedEOF},
+		{"corrupt gzip checksum", "gzip", append(slices.Clone(gzipped[:len(gzipped)-8]), 0, 0, 0, 0, 0, 0, 0, 0), "Bad Request: corrupt gzip stream: gzip: invalid checksum", gzip.ErrChecksum},
+		{"truncated deflate stream", "deflate", deflated[:len(deflated)/2], "Bad Request: truncated deflate stream: unexpected EOF", io.ErrUnexpectedEOF},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			header := http.Header{"Content-Encoding": {tc.encoding}}
+			_, err := io.ReadAll(NewBodyReader(io.NopCloser(bytes.NewReader(tc.body)), header, int64(len(tc.body))))
+
+			var badRequest *BadRequestError
+			assertEqual(t, true, errors.As(err, &badRequest))
+			assertEqual(t, tc.encoding, badRequest.Coding)
+			assertEqual(t, 1, badRequest.Layer)
+			assertEqual(t, tc.message, err.Error())
+			assertEqual(t, true, errors.Is(err, tc.is))
+		})
+	}
+
+	t.Run("corrupt deflate stream", func(t *testing.T) {
+		t.Parallel()
+		header := http.Header{"Content-Encoding": {"deflate"}}
+		_, err := io.ReadAll(NewBodyReader(io.NopCloser(bytes.NewReader(corruptDeflate)), header, int64(len(corruptDeflate))))
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, "deflate", badRequest.Coding)
+		var corrupt flate.CorruptInputError
+		assertEqual(t, true, errors.As(err, &corrupt))
+		if !strings.HasPrefix(err.Error(), "Bad Request: corrupt deflate stream: ") {
+			t.Errorf("unexpected message: %v", err)
+		}
+	})
+
+	t.Run("names the failing layer", func(t *testing.T) {
+		t.Parallel()
+		body := gzipBytes(t, deflated[:len(deflated)/2])
+		header := http.Header{"Content-Encoding": {"deflate, gzip"}}
+		_, err := io.ReadAll(NewBodyReader(io.NopCloser(bytes.NewReader(body)), header, int64(len(body))))
+
+		assertEqual(t, "Bad Request: truncated deflate stream: unexpected EOF", err.Error())
+	})
+}
+
+func TestPassthroughUnknownEncoding(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	type result struct {
+		body                       []byte
+		err                        error
+		unhandledBefore, unhandled string
+		applied                    []string
+	}
+	serve := func(t *testing.T, encoding string, body []byte, opts ...Option) result {
+		t.Helper()
+		var got result
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			got.unhandledBefore = UnhandledEncoding(r)
+			got.body, got.err = io.ReadAll(r.Body)
+			got.unhandled = UnhandledEncoding(r)
+			got.applied = AppliedEncodings(r)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", encoding)
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		return got
+	}
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		got := serve(t, "br", brotliBytes(t, sourceData), DisableEncoding("br"))
+
+		var unsupported *RequestUnsupportedMediaTypeError
+		assertEqual(t, true, errors.As(got.err, &unsupported))
+		assertEqual(t, "", got.unhandled)
+	})
+
+	t.Run("passes the body through untouched", func(t *testing.T) {
+		t.Parallel()
+		encoded := brotliBytes(t, sourceData)
+		got := serve(t, "br", encoded, DisableEncoding("br"), PassthroughUnknownEncoding(true))
+
+		assertNoError(t, got.err)
+		assertEqual(t, encoded, got.body)
+		assertEqual(t, "br", got.unhandledBefore)
+		assertEqual(t, "br", got.unhandled)
+		assertEqual(t, []string{}, got.applied)
+	})
+
+	t.Run("doesn't decode supported codings", func(t *testing.T) {
+		t.Parallel()
+		encoded := gzipBytes(t, sourceData)
+		got := serve(t, "gzip, X-Custom", encoded, PassthroughUnknownEncoding(true))
+
+		assertNoError(t, got.err)
+		assertEqual(t, encoded, got.body)
+		assertEqual(t, "X-Custom", got.unhandled)
+		assertEqual(t, []string{}, got.applied)
+	})
+
+	t.Run("supported codings are decoded", func(t *testing.T) {
+		t.Parallel()
+		got := serve(t, "gzip", gzipBytes(t, sourceData), PassthroughUnknownEncoding(true))
+
+		assertNoError(t, got.err)
+		assertEqual(t, sourceData, got.body)
+		assertEqual(t, "", got.unhandledBefore)
+		assertEqual(t, "", got.unhandled)
+		assertEqual(t, []string{"gzip"}, got.applied)
+	})
+
+	t.Run("content checks aren't applied to the encoded body", func(t *testing.T) {
+		t.Parallel()
+		encoded := gzipBytes(t, sourceData)
+		got := serve(t, "custom", encoded, PassthroughUnknownEncoding(true), RejectControlCharacters(true))
+
+		assertNoError(t, got.err)
+		assertEqual(t, encoded, got.body)
+	})
+
+	t.Run("limits the encoded body", func(t *testing.T) {
+		t.Parallel()
+		got := serve(t, "custom", sourceData, PassthroughUnknownEncoding(true), ContentLengthLimit(10))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(got.err, &tooLarge))
+	})
+
+	t.Run("empty body", func(t *testing.T) {
+		t.Parallel()
+		got := serve(t, "custom", nil, PassthroughUnknownEncoding(true))
+
+		assertNoError(t, got.err)
+		assertEqual(t, "custom", got.unhandled)
+	})
+}
+
+func TestBadRequestErrorUnwrap(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	serve := func(t *testing.T, req *http.Request) error {
+		t.Helper()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+		return <-errs
+	}
+
+	t.Run("truncated body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", io.MultiReader(bytes.NewReader(sourceData[:10]), iotest.ErrReader(io.ErrUnexpectedEOF)))
+
+		err := serve(t, req)
+
+		var badRequest *BadRequestError
+		assertEqual(t, true, errors.As(err, &badRequest))
+		assertEqual(t, true, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+
+	t.Run("truncated encoded body", func(t *testing.T) {
+		t.Parallel()
+		compressed := gzipBytes(t, sourceData)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed[:len(compressed)/2]))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		err := serve(t, req)
+
+		assertEqual(t, true, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+
+	t.Run("malformed body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("this is not gzip data"))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		err := serve(t, req)
+
+		assertEqual(t, true, errors.Is(err, gzip.ErrHeader))
+		assertEqual(t, false, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+}
+
+func TestContentTooLargeStatus(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20))
+	serve := func(t *testing.T, handler http.HandlerFunc, req *http.Request, opts ...Option) int {
+		t.Helper()
+		response := httptest.NewRecorder()
+		RequestBodyHandler(handler, opts...).ServeHTTP(response, req)
+		return response.Code
+	}
+	newRequest := func(encoding string, body []byte) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		return req
+	}
+
+	t.Run("default status", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, serve(t, echoHandler(), newRequest("", sourceData), ContentLengthLimit(100)))
+	})
+
+	t.Run("declared length", func(t *testing.T) {
+		t.Parallel()
+
+		code := serve(t, echoHandler(), newRequest("", sourceData), ContentLengthLimit(100), ContentTooLargeStatus(http.StatusBadRequest))
+
+		assertEqual(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("decoded body", func(t *testing.T) {
+		t.Parallel()
+
+		code := serve(t, echoHandler(), newRequest("gzip", gzipBytes(t, sourceData)), ContentLengthLimit(100), ContentTooLargeStatus(http.StatusBadRequest))
+
+		assertEqual(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("per route", func(t *testing.T) {
+		t.Parallel()
+		handler := echoHandler(ContentTooLargeStatus(http.StatusBadRequest))
+
+		assertEqual(t, http.StatusBadRequest, serve(t, handler, newRequest("", sourceData), ContentLengthLimit(100)))
+	})
+
+	t.Run("restore the default", func(t *testing.T) {
+		t.Parallel()
+		handler := echoHandler(ContentTooLargeStatus(0))
+
+		code := serve(t, handler, newRequest("", sourceData), ContentLengthLimit(100), ContentTooLargeStatus(http.StatusBadRequest))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, code)
+	})
+
+	t.Run("returned errors", func(t *testing.T) {
+		t.Parallel()
+		for _, read := range []func(r *http.Request) ([]byte, error){
+			func(r *http.Request) ([]byte, error) { return io.ReadAll(r.Body) },
+			ReadAll,
+		} {
+			errs := make(chan error, 1)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				_, err := read(r)
+				errs <- err
+			}
+			req := newRequest("", sourceData)
+			req.ContentLength = -1
+			serve(t, handler, req, ContentLengthLimit(100), ContentTooLargeStatus(http.StatusBadRequest), ReturnOnError())
+
+			bodyErr, ok := AsRequestBodyError(<-errs)
+			assertEqual(t, true, ok)
+			assertEqual(t, http.StatusBadRequest, bodyErr.RecommendedStatusCode())
+		}
+	})
+
+	t.Run("buffer limit", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := ReadAll(r)
+			errs <- err
+		}
+		serve(t, handler, newRequest("", sourceData), MaxTotalBufferBytes(100), ContentTooLargeStatus(http.StatusBadRequest))
+
+		bodyErr, ok := AsRequestBodyError(<-errs)
+		assertEqual(t, true, ok)
+		assertEqual(t, http.StatusBadRequest, bodyErr.RecommendedStatusCode())
+	})
+}
+
+func TestMaxCompressionRatio(t *testing.T) {
+	t.Parallel()
+
+	serve := func(t *testing.T, encoding string, body []byte, opts ...Option) (int64, error) {
+		t.Helper()
+		type result struct {
+			read int64
+			err  error
+		}
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			read, err := io.Copy(io.Discard, r.Body)
+			results <- result{read, err}
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		got := <-results
+		return got.read, got.err
+	}
+	// Zeros compress by a factor of about 1000, while fitting within the default content length limit.
+	bomb := gzipBytes(t, make([]byte, 8*1024*1024))
+
+	t.Run("stops a compression bomb while decoding", func(t *testing.T) {
+		t.Parallel()
+
+		read, err := serve(t, "gzip", bomb, MaxCompressionRatio(100))
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(err, &tooLarge))
+		if read >= 1024*1024 {
+			t.Errorf("Expected decoding to stop early, read %d bytes", read)
+		}
+		if tooLarge.Read > tooLarge.Limit+64*1024 {
+			t.Errorf("Expected decoding to stop near the limit of %d bytes, read %d", tooLarge.Limit, tooLarge.Read)
+		}
+	})
+
+	t.Run("allows small bodies which compress well", func(t *testing.T) {
+		t.Parallel()
+		data := bytes.Repeat([]byte("a"), 100*1024)
+
+		read, err := serve(t, "gzip", gzipBytes(t, data), MaxCompressionRatio(100))
+
+		assertNoError(t, err)
+		assertEqual(t, int64(len(data)), read)
+	})
+
+	t.Run("allows bodies within the ratio", func(t *testing.T) {
+		t.Parallel()
+		data := pseudoRandomBytes(256 * 1024)
+
+		read, err := serve(t, "deflate", deflateBytes(t, data), MaxCompressionRatio(2))
+
+		assertNoError(t, err)
+		assertEqual(t, int64(len(data)), read)
+	})
+
+	t.Run("unencoded bodies aren't checked", func(t *testing.T) {
+		t.Parallel()
+		data := make([]byte, 64*1024)
+
+		read, err := serve(t, "", data, MaxCompressionRatio(0.5))
+
+		assertNoError(t, err)
+		assertEqual(t, int64(len(data)), read)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+
+		read, err := serve(t, "gzip", bomb)
+
+		assertNoError(t, err)
+		assertEqual(t, int64(8*1024*1024), read)
+	})
+}
+
+func TestStrictAdvertisedEncodings(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("alias rejected in strict mode", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), StrictAdvertisedEncodings(true))
+
+		response := postEncoded(t, ts, "x-gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("advertised encoding accepted in strict mode", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), StrictAdvertisedEncodings(true))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("alias accepted by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "x-gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestDecodeByteBudget(t *testing.T) {
+	t.Parallel()
+
+	// Random data doesn't compress, so each decoder in the chain reads roughly the input size.
+	sourceData := make([]byte, 1000)
+	_, err := rand.New(rand.NewSource(1)).Read(sourceData)
+	assertNoError(t, err)
+	encoded := gzipBytes(t, deflateBytes(t, sourceData))
+
+	t.Run("chain within budget", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), DecodeByteBudget(4000))
+
+		response := postEncoded(t, ts, "deflate, gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("chain over budget", func(t *testing.T) {
+		t.Parallel()
+		// Each layer reads less than the budget, but the combined input exceeds it.
+		ts := setupServer(t, echoHandler(), DecodeByteBudget(1500))
+
+		response := postEncoded(t, ts, "deflate, gzip", encoded)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("maximum budget", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), DecodeByteBudget(math.MaxInt64))
+
+		response := postEncoded(t, ts, "deflate, gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+}
+
+func TestInspectGzipExtra(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Header.Extra = []byte("tenant=acme")
+	_, err := gz.Write(sourceData)
+	assertNoError(t, err)
+	assertNoError(t, gz.Close())
+	encoded := buf.Bytes()
+
+	expectTenant := func(tenant string) func(extra []byte) error {
+		return func(extra []byte) error {
+			if string(extra) != "tenant="+tenant {
+				return fmt.Errorf("unexpected tenant in %q", extra)
+			}
+			return nil
+		}
+	}
+
+	t.Run("valid extra field", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), InspectGzipExtra(expectTenant("acme")))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("invalid extra field", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), InspectGzipExtra(expectTenant("other")))
+
+		response := postEncoded(t, ts, "gzip", encoded)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("not applied to deflate", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), InspectGzipExtra(expectTenant("other")))
+
+		response := postEncoded(t, ts, "deflate", deflateBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestMaxFinalRatio(t *testing.T) {
+	t.Parallel()
+
+	t.Run("high ratio caught at EOF", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxFinalRatio(100))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 1024*1024)))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.StatusCode)
+	})
+
+	t.Run("low ratio allowed", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MaxFinalRatio(100))
+		sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+}
+
+func TestMinCompressionRatio(t *testing.T) {
+	t.Parallel()
+
+	sourceData := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog\n"), 100)
+	storeOnly := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		gz, err := gzip.NewWriterLevel(&buf, gzip.NoCompression)
+		assertNoError(t, err)
+		_, err = gz.Write(data)
+		assertNoError(t, err)
+		assertNoError(t, gz.Close())
+		return buf.Bytes()
+	}
+
+	t.Run("barely compressed rejected at EOF", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MinCompressionRatio(1.5))
+
+		response := postEncoded(t, ts, "gzip", storeOnly(t, sourceData))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("compressed allowed", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MinCompressionRatio(1.5))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("unencoded allowed", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MinCompressionRatio(1.5))
+
+		response := postEncoded(t, ts, "", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "gzip", storeOnly(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func TestSupportEncodingNonChainable(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	// The stateful codec is stood in for by deflate under a custom name.
+	nonChainable := SupportEncodingNonChainable("stateful", DeflateEncodingReader)
+
+	t.Run("used alone", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), nonChainable)
+
+		response := postEncoded(t, ts, "stateful", deflateBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("combined with gzip", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), nonChainable)
+
+		response := postEncoded(t, ts, "stateful, gzip", gzipBytes(t, deflateBytes(t, sourceData)))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+}
+
+func TestMultiFrame(t *testing.T) {
+	t.Parallel()
+
+	first := []byte("The quick brown fox ")
+	second := []byte("jumps over the lazy dog")
+	concatenated := append(gzipBytes(t, first), gzipBytes(t, second)...)
+
+	t.Run("gzip members decoded by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "gzip", concatenated)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, string(first)+string(second), string(responseBody))
+	})
+
+	t.Run("gzip members enabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("gzip", true))
+
+		response := postEncoded(t, ts, "gzip", concatenated)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, string(first)+string(second), string(responseBody))
+	})
+
+	t.Run("gzip members disabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("gzip", false))
+
+		response := postEncoded(t, ts, "gzip", concatenated)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("single gzip member with multiple frames disabled", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("gzip", false))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, first))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, first, responseBody)
+	})
+}
+
+func TestRequireFullConsumption(t *testing.T) {
+	t.Parallel()
+
+	readHalf := func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadFull(r.Body, make([]byte, 50))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	t.Run("handler reads half the body", func(t *testing.T) {
+		t.Parallel()
+		drained := make(chan int64, 1)
+		ts := setupServer(t, readHalf, RequireFullConsumption(true),
+			OnPartialConsumption(func(r *http.Request, n int64) { drained <- n }))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 100)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, int64(50), <-drained)
+	})
+
+	t.Run("handler reads the whole body", func(t *testing.T) {
+		t.Parallel()
+		drained := make(chan int64, 1)
+		ts := setupServer(t, echoHandler(), RequireFullConsumption(true),
+			OnPartialConsumption(func(r *http.Request, n int64) { drained <- n }))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, make([]byte, 100)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		_, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		select {
+		case n := <-drained:
+			t.Errorf("Expected no partial consumption, got %d bytes drained", n)
+		default:
+		}
+	})
+}
+
+func TestSupportEncodingPrefix(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("vendor coding resolved by prefix", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingPrefix("vnd.acme.", GZipEncodingReader))
+
+		response := postEncoded(t, ts, "vnd.acme.gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("exact match before prefix", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(),
+			SupportEncodingPrefix("vnd.acme.", GZipEncodingReader),
+			SupportEncoding("vnd.acme.deflate", DeflateEncodingReader))
+
+		response := postEncoded(t, ts, "vnd.acme.deflate", deflateBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("longest prefix wins", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(),
+			SupportEncodingPrefix("vnd.", GZipEncodingReader),
+			SupportEncodingPrefix("vnd.acme.", DeflateEncodingReader))
+
+		response := postEncoded(t, ts, "vnd.acme.custom", deflateBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("unmatched prefix", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), SupportEncodingPrefix("vnd.acme.", GZipEncodingReader))
+
+		response := postEncoded(t, ts, "vnd.other.gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+}
+
+func setupServer(t *testing.T, h http.HandlerFunc, globalDefaults ...Option) *httptest.Server {
+	t.Helper()
+
+	var handler http.Handler = h
+	handler = RequestBodyHandler(handler, globalDefaults...)
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func echoHandler(options ...Option) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(options) > 0 {
+			SetRequestBodyOption(r, options...)
+		}
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("An error occurred"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(bodyBytes)
+	}
+}
+
+func postEncoded(t *testing.T, ts *httptest.Server, contentEncoding string, body []byte) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+	assertNoError(t, err)
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	response, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	return response
+}
+
+func readString(t *testing.T, response *http.Response) string {
+	t.Helper()
+
+	responseBody, err := io.ReadAll(response.Body)
+	assertNoError(t, err)
+	return string(responseBody)
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	assertNoError(t, err)
+	assertNoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+// defaultEncodings returns the encodings advertised by default along with any extra encodings, sorted.
+// br and zstd are only included when their decoders are built.
+func defaultEncodings(extra ...string) []string {
+	encodings := append([]string{"deflate", "gzip"}, extra...)
+	if brotliAvailable {
+		encodings = append(encodings, "br")
+	}
+	if zstdAvailable {
+		encodings = append(encodings, "zstd")
+	}
+	slices.Sort(encodings)
+	return encodings
+}
+
+func deflateBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	deflate, err := flate.NewWriter(&buf, flate.BestCompression)
+	assertNoError(t, err)
+	_, err = deflate.Write(data)
+	assertNoError(t, err)
+	assertNoError(t, deflate.Close())
+	return buf.Bytes()
 }
 
 func assertNoError(t *testing.T, err error) {
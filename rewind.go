@@ -0,0 +1,92 @@
+package requestbody
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errNotRewindable is returned by Rewind when the body isn't being buffered for rewinding.
+var errNotRewindable = errors.New("requestbody: body isn't rewindable, as Rewindable wasn't set before it was read")
+
+// Rewindable buffers the decoded body in memory as it's read, up to maxBuffer bytes, so Rewind can reset the
+// body to the start, such as for a middleware which verifies a signature over the body before passing it on to
+// the handler. The option must be set before the body is first read.
+//
+// The buffer is held until the request completes, so each request can hold up to maxBuffer bytes in memory,
+// and it counts towards the MaxTotalBufferBytes limit for the request. Prefer setting it with
+// SetRequestBodyOption only for the routes which need it. Once the body exceeds maxBuffer, the buffer is
+// released and reading continues, but Rewind returns a RequestContentTooLargeError.
+// This is disabled by default, or when set to zero or less.
+func Rewindable(maxBuffer int64) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.rewindBuffer = maxBuffer
+		},
+	}
+}
+
+// Rewind resets the body to the start when using Rewindable, so the bytes already read are read again before
+// the rest of the body. Rewinding before the body has been read does nothing.
+//
+// If the body exceeded the buffer, a RequestContentTooLargeError is returned, or the error from the
+// MaxTotalBufferBytes limit. Errors are handled in the same way as when reading from the body directly, so
+// when the middleware is configured with an error handler, the error handler will write the response.
+// An error is also returned if the body isn't rewindable, such as when the request wasn't wrapped by the
+// RequestBodyHandler middleware.
+func Rewind(r *http.Request) error {
+	body, ok := bodyFromRequest(r)
+	if !ok || (body.initialized && body.rewind == nil) {
+		return errNotRewindable
+	}
+	if body.rewind == nil {
+		return nil // Nothing has been read yet.
+	}
+	if err := body.rewind.err; err != nil {
+		return body.handleError(err)
+	}
+	body.rewind.pos = 0
+	return nil
+}
+
+// rewindBuffer holds the decoded body read so far when using Rewindable.
+type rewindBuffer struct {
+	max  int64
+	data []byte
+	// pos is the position of the next read within data, which is before the end after rewinding.
+	pos int
+	// err is the reason the body can't be rewound, once the buffer has been released.
+	err RequestBodyError
+}
+
+// replay reads from the buffer after rewinding, returning zero once the buffered bytes are exhausted.
+func (b *rewindBuffer) replay(p []byte) int {
+	n := copy(p, b.data[b.pos:])
+	b.pos += n
+	return n
+}
+
+// recordRewind appends bytes read from the decode chain to the buffer, releasing the buffer once it's full.
+func (r *lazyReader) recordRewind(p []byte) {
+	b := r.rewind
+	if b.err != nil || len(p) == 0 {
+		return
+	}
+	if int64(len(p)) > b.max-int64(len(b.data)) {
+		b.release(r, &RequestContentTooLargeError{
+			Limit: b.max,
+			Read:  r.decodedBytes.Load(),
+		})
+		return
+	}
+	if err := r.chargeBuffer(int64(len(p))); err != nil {
+		b.release(r, err)
+		return
+	}
+	b.data = append(b.data, p...)
+	b.pos = len(b.data)
+}
+
+func (b *rewindBuffer) release(r *lazyReader, err RequestBodyError) {
+	r.releaseBuffer(int64(len(b.data)))
+	b.data, b.pos, b.err = nil, 0, err
+}
@@ -0,0 +1,158 @@
+package requestbody
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRewind(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20))
+	serve := func(t *testing.T, handler http.HandlerFunc, body []byte, encoding string, opts ...Option) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		if encoding != "" {
+			req.Header.Set("Content-Encoding", encoding)
+		}
+		response := httptest.NewRecorder()
+		RequestBodyHandler(handler, opts...).ServeHTTP(response, req)
+		return response
+	}
+
+	t.Run("verify then read again", func(t *testing.T) {
+		t.Parallel()
+		key := []byte("secret")
+		mac := hmac.New(sha256.New, key)
+		mac.Write(sourceData)
+		signature := hex.EncodeToString(mac.Sum(nil))
+
+		// verify checks the signature over the decoded body before passing it on, as a middleware would.
+		verify := func(next http.HandlerFunc) http.HandlerFunc {
+			return func(w http.ResponseWriter, r *http.Request) {
+				SetRequestBodyOption(r, Rewindable(int64(len(sourceData))))
+				mac := hmac.New(sha256.New, key)
+				if _, err := io.Copy(mac, r.Body); err != nil {
+					return
+				}
+				if hex.EncodeToString(mac.Sum(nil)) != signature {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if err := Rewind(r); err != nil {
+					return
+				}
+				next(w, r)
+			}
+		}
+		var completed int
+		response := serve(t, verify(echoHandler()), gzipBytes(t, sourceData), "gzip", OnBodyComplete(func(r *http.Request, bytesRead int64, encoding string) {
+			completed++
+		}))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+		assertEqual(t, 1, completed)
+	})
+
+	t.Run("rewind part way", func(t *testing.T) {
+		t.Parallel()
+		var first, second []byte
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			first = make([]byte, 10)
+			_, err := io.ReadFull(r.Body, first)
+			assertNoError(t, err)
+			assertNoError(t, Rewind(r))
+			second, err = io.ReadAll(r.Body)
+			assertNoError(t, err)
+			assertNoError(t, Rewind(r))
+			again, err := io.ReadAll(r.Body)
+			assertNoError(t, err)
+			assertEqual(t, second, again)
+		}
+		serve(t, handler, sourceData, "", Rewindable(1024))
+
+		assertEqual(t, sourceData[:10], first)
+		assertEqual(t, sourceData, second)
+	})
+
+	t.Run("rewind before reading", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			assertNoError(t, Rewind(r))
+			data, err := io.ReadAll(r.Body)
+			assertNoError(t, err)
+			assertEqual(t, sourceData, data)
+		}
+		serve(t, handler, sourceData, "", Rewindable(1024))
+	})
+
+	t.Run("body exceeds the buffer", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			data, err := io.ReadAll(r.Body)
+			assertNoError(t, err)
+			assertEqual(t, sourceData, data)
+			errs <- Rewind(r)
+		}
+		serve(t, handler, sourceData, "", Rewindable(100), ReturnOnError())
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(<-errs, &tooLarge))
+		assertEqual(t, int64(100), tooLarge.Limit)
+	})
+
+	t.Run("error handler writes the response", func(t *testing.T) {
+		t.Parallel()
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			_ = Rewind(r)
+			t.Error("Expected the error handler to stop the handler")
+		}
+		response := serve(t, handler, sourceData, "", Rewindable(100))
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("counts towards the total buffer limit", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			errs <- Rewind(r)
+		}
+		serve(t, handler, sourceData, "", Rewindable(1024), MaxTotalBufferBytes(100), ReturnOnError())
+
+		var tooLarge *RequestContentTooLargeError
+		assertEqual(t, true, errors.As(<-errs, &tooLarge))
+		assertEqual(t, int64(100), tooLarge.Limit)
+	})
+
+	t.Run("set after reading", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = r.Body.Read(make([]byte, 1))
+			SetRequestBodyOption(r, Rewindable(1024))
+			errs <- Rewind(r)
+		}
+		serve(t, handler, sourceData, "")
+
+		assertEqual(t, errNotRewindable, <-errs)
+	})
+
+	t.Run("without the middleware", func(t *testing.T) {
+		t.Parallel()
+
+		assertEqual(t, errNotRewindable, Rewind(httptest.NewRequest(http.MethodPost, "/", nil)))
+	})
+}
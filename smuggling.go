@@ -0,0 +1,64 @@
+package requestbody
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AntiSmuggling rejects requests with headers which are inconsistent about how the body is framed,
+// which is a common sign of HTTP request smuggling. When enabled (the default), the following
+// return a BadRequestError before the wrapped handler is called:
+//   - both Content-Length and Transfer-Encoding being present,
+//   - multiple differing Content-Length values,
+//   - obsolete line folding in the Content-Length, Transfer-Encoding, Content-Encoding
+//     or Content-Type headers.
+//
+// The net/http server already rejects some of these, but requests rewritten by proxies or
+// other transports may not have had the same guarantees applied.
+// This option only has an effect when passed to RequestBodyHandler.
+func AntiSmuggling(enable bool) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.antiSmuggling = enable
+		},
+	}
+}
+
+// framingHeaders are checked for obsolete line folding.
+var framingHeaders = []string{"Content-Length", "Transfer-Encoding", "Content-Encoding", "Content-Type"}
+
+func checkSmuggling(r *http.Request) RequestBodyError {
+	for _, name := range framingHeaders {
+		for _, value := range r.Header.Values(name) {
+			if strings.ContainsAny(value, "\r\n") {
+				return &BadRequestError{
+					Err: fmt.Errorf("obsolete line folding in %s header", name),
+				}
+			}
+		}
+	}
+
+	contentLengths := r.Header.Values("Content-Length")
+	if len(contentLengths) > 0 && (len(r.TransferEncoding) > 0 || len(r.Header.Values("Transfer-Encoding")) > 0) {
+		return &BadRequestError{
+			Err: errors.New("both Content-Length and Transfer-Encoding are present"),
+		}
+	}
+
+	var contentLength string
+	for _, value := range contentLengths {
+		for _, length := range strings.Split(value, ",") {
+			length = strings.TrimSpace(length)
+			if contentLength == "" {
+				contentLength = length
+			} else if length != contentLength {
+				return &BadRequestError{
+					Err: errors.New("multiple differing Content-Length values"),
+				}
+			}
+		}
+	}
+	return nil
+}
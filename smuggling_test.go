@@ -0,0 +1,106 @@
+package requestbody
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAntiSmuggling(t *testing.T) {
+	t.Parallel()
+
+	// Requests are served directly as the net/http server would reject some of them before
+	// the middleware is reached.
+	serve := func(t *testing.T, req *http.Request, opts ...Option) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), opts...).ServeHTTP(recorder, req)
+		return recorder
+	}
+	newRequest := func(t *testing.T) *http.Request {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+		req.Header.Set("Content-Length", "5")
+		return req
+	}
+
+	t.Run("consistent request", func(t *testing.T) {
+		t.Parallel()
+		response := serve(t, newRequest(t))
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, "hello", response.Body.String())
+	})
+
+	t.Run("content length and transfer encoding", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.TransferEncoding = []string{"chunked"}
+
+		response := serve(t, req)
+
+		assertEqual(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("multiple differing content lengths", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.Header.Add("Content-Length", "6")
+
+		response := serve(t, req)
+
+		assertEqual(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("comma separated differing content lengths", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.Header.Set("Content-Length", "5, 6")
+
+		response := serve(t, req)
+
+		assertEqual(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("repeated identical content lengths", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.Header.Add("Content-Length", "5")
+
+		response := serve(t, req)
+
+		assertEqual(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("obsolete line folding", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.Header.Set("Content-Encoding", "gzip,\r\n deflate")
+
+		response := serve(t, req)
+
+		assertEqual(t, http.StatusBadRequest, response.Code)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.Header.Add("Content-Length", "6")
+
+		response := serve(t, req, AntiSmuggling(false))
+
+		assertEqual(t, http.StatusOK, response.Code)
+	})
+
+	t.Run("returned on read", func(t *testing.T) {
+		t.Parallel()
+		req := newRequest(t)
+		req.TransferEncoding = []string{"chunked"}
+
+		response := serve(t, req, ReturnOnError())
+
+		// The echo handler fails to read the body.
+		assertEqual(t, http.StatusInternalServerError, response.Code)
+	})
+}
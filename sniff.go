@@ -0,0 +1,68 @@
+package requestbody
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the maximum number of bytes considered by http.DetectContentType.
+const sniffLength = 512
+
+// sniffReader buffers the start of the decoded body to compare the sniffed content type
+// against the declared media type before any bytes are returned.
+type sniffReader struct {
+	io.ReadCloser
+	declared string
+	sniffed  bool
+	buf      []byte
+	err      error
+}
+
+func (s *sniffReader) Read(p []byte) (int, error) {
+	if !s.sniffed {
+		s.sniffed = true
+		buf := make([]byte, sniffLength)
+		n, err := io.ReadFull(s.ReadCloser, buf)
+		s.buf = buf[:n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			s.err = io.EOF
+		} else if err != nil {
+			s.err = err
+		}
+		if n > 0 && isMislabeled(s.declared, http.DetectContentType(s.buf)) {
+			s.buf = nil
+			s.err = &RequestUnsupportedMediaTypeError{
+				ContentType: s.declared,
+				Header:      "Content-Type",
+				Value:       s.declared,
+			}
+		}
+	}
+	if len(s.buf) > 0 {
+		n := copy(p, s.buf)
+		s.buf = s.buf[n:]
+		return n, nil
+	}
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.ReadCloser.Read(p)
+}
+
+// isMislabeled reports whether the sniffed content type is clearly not the declared media type.
+func isMislabeled(declared, sniffed string) bool {
+	sniffed, _, _ = strings.Cut(sniffed, ";")
+	if sniffed == "application/octet-stream" || strings.HasPrefix(sniffed, "text/") {
+		// Sniffing can't tell textual formats, such as JSON and XML, apart.
+		return false
+	}
+	if declared == sniffed || declared == "application/octet-stream" {
+		return false
+	}
+	declaredType, _, _ := strings.Cut(declared, "/")
+	sniffedType, _, _ := strings.Cut(sniffed, "/")
+	// Allow mismatched subtypes within the same family, such as image/jpeg and image/png,
+	// but not for "application" where the subtypes are unrelated.
+	return declaredType != sniffedType || declaredType == "application"
+}
@@ -0,0 +1,69 @@
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestRejectMislabeledContentType(t *testing.T) {
+	t.Parallel()
+
+	pngData := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 100)...)
+
+	post := func(t *testing.T, contentType string, body []byte) *http.Response {
+		t.Helper()
+		ts := setupServer(t, echoHandler(), RejectMislabeledContentType(true))
+		response, err := ts.Client().Post(ts.URL, contentType, bytes.NewReader(body))
+		assertNoError(t, err)
+		t.Cleanup(func() { response.Body.Close() })
+		return response
+	}
+
+	t.Run("png labeled as json", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/json", pngData)
+
+		assertEqual(t, http.StatusUnsupportedMediaType, response.StatusCode)
+	})
+
+	t.Run("png labeled as png", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "image/png", pngData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, pngData, responseBody)
+	})
+
+	t.Run("json labeled as json", func(t *testing.T) {
+		t.Parallel()
+		sourceData := []byte(`{"hello":"world"}`)
+		response := post(t, "application/json", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("larger than sniff length", func(t *testing.T) {
+		t.Parallel()
+		sourceData := bytes.Repeat([]byte("0123456789"), 100)
+		response := post(t, "application/json", sourceData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		responseBody, err := io.ReadAll(response.Body)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, responseBody)
+	})
+
+	t.Run("png labeled as octet stream", func(t *testing.T) {
+		t.Parallel()
+		response := post(t, "application/octet-stream", pngData)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+	})
+}
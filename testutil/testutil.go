@@ -0,0 +1,129 @@
+// Package testutil provides fixtures for testing and benchmarking handlers wrapped by the
+// requestbody middleware, such as generating bodies of a given size and building requests
+// with specific encodings and lengths.
+package testutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CompressibleBody returns a body of the given size made of repeated text, which
+// compresses to a small fraction of its size.
+func CompressibleBody(size int) []byte {
+	const text = "The quick brown fox jumps over the lazy dog\n"
+	return bytes.Repeat([]byte(text), size/len(text)+1)[:size]
+}
+
+// IncompressibleBody returns a body of the given size made of pseudo-random bytes, which
+// doesn't shrink when compressed. The same size always returns the same bytes.
+func IncompressibleBody(size int) []byte {
+	body := make([]byte, size)
+	_, _ = rand.New(rand.NewSource(int64(size))).Read(body)
+	return body
+}
+
+// Encode applies the content-codings to the data in the order they would be listed in a
+// Content-Encoding header, so the first coding is applied first.
+// Supported codings are "gzip", "x-gzip", "deflate", "br", "zstd" and "identity".
+func Encode(data []byte, encodings ...string) ([]byte, error) {
+	for _, encoding := range encodings {
+		var buf bytes.Buffer
+		var writer io.WriteCloser
+		switch strings.ToLower(strings.TrimSpace(encoding)) {
+		case "gzip", "x-gzip":
+			writer = gzip.NewWriter(&buf)
+		case "deflate":
+			var err error
+			writer, err = flate.NewWriter(&buf, flate.DefaultCompression)
+			if err != nil {
+				return nil, err
+			}
+		case "br":
+			writer = brotli.NewWriter(&buf)
+		case "zstd":
+			var err error
+			writer, err = zstd.NewWriter(&buf)
+			if err != nil {
+				return nil, err
+			}
+		case "identity":
+			continue
+		default:
+			return nil, fmt.Errorf("testutil: unsupported encoding %q", encoding)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		data = buf.Bytes()
+	}
+	return data, nil
+}
+
+// NewRequest returns a request for serving directly to a handler, with the body encoded using
+// the content-codings and the Content-Encoding header set to match. The request declares the
+// length of the encoded body, which can be changed using DeclaredLength or UnknownLength.
+// NewRequest panics if the body can't be encoded.
+func NewRequest(method, target string, body []byte, encodings ...string) *http.Request {
+	encoded, err := Encode(body, encodings...)
+	if err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(encoded))
+	if len(encodings) > 0 {
+		req.Header.Set("Content-Encoding", strings.Join(encodings, ", "))
+	}
+	return req
+}
+
+// DeclaredLength overrides the declared length of the request body.
+func DeclaredLength(req *http.Request, length int64) *http.Request {
+	req.ContentLength = length
+	return req
+}
+
+// UnknownLength removes the declared length of the request body, as with a chunked request.
+func UnknownLength(req *http.Request) *http.Request {
+	return DeclaredLength(req, -1)
+}
+
+// BenchmarkHandler serves a new request from newRequest to the handler for each benchmark
+// iteration, reporting allocations and the number of bytes in each request body.
+// It can be used as a template for benchmarking middleware configurations:
+//
+//	func BenchmarkGzip(b *testing.B) {
+//		body := testutil.CompressibleBody(1024 * 1024)
+//		handler := requestbody.RequestBodyHandler(myHandler)
+//		testutil.BenchmarkHandler(b, handler, func() *http.Request {
+//			return testutil.NewRequest(http.MethodPost, "/", body, "gzip")
+//		})
+//	}
+func BenchmarkHandler(b *testing.B, handler http.Handler, newRequest func() *http.Request) {
+	b.Helper()
+	b.ReportAllocs()
+	if req := newRequest(); req.ContentLength > 0 {
+		b.SetBytes(req.ContentLength)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		req := newRequest()
+		recorder := httptest.NewRecorder()
+		b.StartTimer()
+		handler.ServeHTTP(recorder, req)
+	}
+}
@@ -0,0 +1,162 @@
+package testutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/danielrbradley/requestbody"
+)
+
+func TestBodies(t *testing.T) {
+	t.Parallel()
+
+	t.Run("compressible body", func(t *testing.T) {
+		t.Parallel()
+		body := CompressibleBody(10000)
+		encoded, err := Encode(body, "gzip")
+		assertNoError(t, err)
+
+		assertEqual(t, 10000, len(body))
+		if len(encoded) > len(body)/10 {
+			t.Errorf("Expected body to compress to under 10%%, got %d bytes", len(encoded))
+		}
+	})
+
+	t.Run("incompressible body", func(t *testing.T) {
+		t.Parallel()
+		body := IncompressibleBody(10000)
+		encoded, err := Encode(body, "gzip")
+		assertNoError(t, err)
+
+		assertEqual(t, 10000, len(body))
+		assertEqual(t, body, IncompressibleBody(10000))
+		if len(encoded) < len(body) {
+			t.Errorf("Expected body not to compress, got %d bytes", len(encoded))
+		}
+	})
+}
+
+func TestEncode(t *testing.T) {
+	t.Parallel()
+
+	body := CompressibleBody(1000)
+
+	t.Run("gzip", func(t *testing.T) {
+		t.Parallel()
+		encoded, err := Encode(body, "gzip")
+		assertNoError(t, err)
+
+		reader, err := gzip.NewReader(bytes.NewReader(encoded))
+		assertNoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		assertNoError(t, err)
+		assertEqual(t, body, decoded)
+	})
+
+	t.Run("deflate then gzip", func(t *testing.T) {
+		t.Parallel()
+		encoded, err := Encode(body, "deflate", "gzip")
+		assertNoError(t, err)
+
+		gz, err := gzip.NewReader(bytes.NewReader(encoded))
+		assertNoError(t, err)
+		decoded, err := io.ReadAll(flate.NewReader(gz))
+		assertNoError(t, err)
+		assertEqual(t, body, decoded)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+		_, err := Encode(body, "compress")
+		if err == nil {
+			t.Errorf("Expected an error for an unsupported encoding")
+		}
+	})
+}
+
+func TestNewRequest(t *testing.T) {
+	t.Parallel()
+
+	body := CompressibleBody(1000)
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		decoded, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(decoded)
+	}
+
+	t.Run("decoded by middleware", func(t *testing.T) {
+		t.Parallel()
+		req := NewRequest(http.MethodPost, "/", body, "deflate", "gzip")
+		assertEqual(t, "deflate, gzip", req.Header.Get("Content-Encoding"))
+
+		recorder := httptestRecorder(requestbody.RequestBodyHandler(http.HandlerFunc(echo)), req)
+
+		assertEqual(t, http.StatusOK, recorder.Code)
+		assertEqual(t, body, recorder.Body.Bytes())
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		t.Parallel()
+		req := UnknownLength(NewRequest(http.MethodPost, "/", body, "gzip"))
+
+		recorder := httptestRecorder(requestbody.RequestBodyHandler(http.HandlerFunc(echo),
+			requestbody.RequireContentLength(true)), req)
+
+		assertEqual(t, http.StatusLengthRequired, recorder.Code)
+	})
+
+	t.Run("declared length", func(t *testing.T) {
+		t.Parallel()
+		req := DeclaredLength(NewRequest(http.MethodPost, "/", body), 2000)
+
+		recorder := httptestRecorder(requestbody.RequestBodyHandler(http.HandlerFunc(echo),
+			requestbody.ContentLengthLimit(1500)), req)
+
+		assertEqual(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	})
+}
+
+func BenchmarkGzipBody(b *testing.B) {
+	body := CompressibleBody(1024 * 1024)
+	handler := requestbody.RequestBodyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	encoded, err := Encode(body, "gzip")
+	if err != nil {
+		b.Fatal(err)
+	}
+	BenchmarkHandler(b, handler, func() *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, "/", bytes.NewReader(encoded))
+		req.Header.Set("Content-Encoding", "gzip")
+		return req
+	})
+}
+
+func httptestRecorder(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func assertNoError(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func assertEqual(t *testing.T, expected, actual interface{}) {
+	t.Helper()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,151 @@
+package requestbody
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// RequestTimeoutError is returned when the request body isn't received in time, such as when
+// a slow client stalls while the decoders are being constructed, or reading exceeds the ReadTimeout.
+// The recommended status code for this error is 408 Request Timeout.
+//
+// See: https://www.rfc-editor.org/rfc/rfc9110.html#name-408-request-timeout
+type RequestTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *RequestTimeoutError) Error() string {
+	return "Request Timeout"
+}
+func (e *RequestTimeoutError) RecommendedStatusCode() int {
+	return http.StatusRequestTimeout
+}
+
+// InitTimeout bounds the time spent constructing decoders before the body is first read, such as
+// reading the gzip header from a slow client. If construction takes longer, a RequestTimeoutError
+// will be returned.
+//
+// When served by net/http without a ReadTimeout, the timeout is applied as a read deadline on the connection,
+// and the deadline is cleared once the decoders are constructed, replacing any read deadline set by the
+// handler. When the server has a ReadTimeout, its deadline is left in place so it still applies to the rest
+// of the body, and as when not served by net/http, construction is abandoned when the timeout expires,
+// though the stalled read continues in the background until the body is closed or the deadline is reached.
+// This is disabled by default, or when set to zero or less.
+func InitTimeout(d time.Duration) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.initTimeout = d
+		},
+	}
+}
+
+// ReadTimeout bounds the time spent reading the body, starting from the first read, such as to stop a
+// slowloris-style client trickling bytes to hold the connection open. Once the timeout expires, reads return a
+// RequestTimeoutError, unless the end of the body has already been reached. The timeout bounds the total time
+// rather than the rate, so it must allow for the largest accepted body at the slowest acceptable rate.
+//
+// As with InitTimeout, when served by net/http without a ReadTimeout the timeout is also applied as a read
+// deadline on the connection, so a client which stops sending entirely is stopped too. The deadline is cleared
+// once the body has been read or the handler returns, replacing any read deadline set by the handler.
+// When the server has a ReadTimeout, its deadline is left in place, and a stalled read only returns once
+// the server's deadline is reached.
+// This is disabled by default, or when set to zero or less.
+func ReadTimeout(d time.Duration) Option {
+	return optionFunc{
+		f: func(opts *options) {
+			opts.readTimeout = d
+		},
+	}
+}
+
+// startReadDeadline starts the deadline for reading the body when using ReadTimeout.
+func (r *lazyReader) startReadDeadline() {
+	r.readDeadline = time.Now().Add(r.options.readTimeout)
+	if server, ok := r.request.Context().Value(http.ServerContextKey).(*http.Server); ok && server.ReadTimeout > 0 {
+		// The server's deadline can't be read back, so changing it could extend or clear it.
+		return
+	}
+	controller := http.NewResponseController(r.writer)
+	if controller.SetReadDeadline(r.readDeadline) == nil {
+		r.clearReadDeadline = func() { _ = controller.SetReadDeadline(time.Time{}) }
+	}
+}
+
+// stopReadDeadline clears the connection's read deadline set by startReadDeadline, so it can't cancel the
+// request once the body has been read.
+func (r *lazyReader) stopReadDeadline() {
+	if r.clearReadDeadline != nil {
+		r.clearReadDeadline()
+		r.clearReadDeadline = nil
+	}
+}
+
+// initDeadline bounds decoder construction when using InitTimeout.
+type initDeadline struct {
+	timeout  time.Duration
+	deadline time.Time
+	// conn is true when the deadline is enforced by the connection's read deadline.
+	conn bool
+}
+
+// startInitDeadline starts the deadline for constructing the decoders, returning nil if there's no
+// timeout or no decoders. The returned stop function clears the read deadline if it was set on the connection.
+func (r *lazyReader) startInitDeadline(decoders int) (*initDeadline, func()) {
+	if r.options.initTimeout <= 0 || decoders == 0 {
+		return nil, func() {}
+	}
+	d := &initDeadline{
+		timeout:  r.options.initTimeout,
+		deadline: time.Now().Add(r.options.initTimeout),
+	}
+	if server, ok := r.request.Context().Value(http.ServerContextKey).(*http.Server); ok && server.ReadTimeout > 0 {
+		// The server's deadline can't be read back, so changing it could extend or clear it.
+		return d, func() {}
+	}
+	// Once constructed, restore the ReadTimeout deadline if it's applied to the connection.
+	var restore time.Time
+	if r.clearReadDeadline != nil {
+		restore = r.readDeadline
+	}
+	controller := http.NewResponseController(r.writer)
+	if controller.SetReadDeadline(d.deadline) == nil {
+		d.conn = true
+		return d, func() { _ = controller.SetReadDeadline(restore) }
+	}
+	return d, func() {}
+}
+
+// newDecoder constructs the decoder, abandoning construction if the deadline is reached.
+func (d *initDeadline) newDecoder(reader EncodingReader, input io.Reader) (io.ReadCloser, error) {
+	if d == nil {
+		return reader(input)
+	}
+	if d.conn {
+		decoder, err := reader(input)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return nil, &RequestTimeoutError{Timeout: d.timeout}
+		}
+		return decoder, err
+	}
+
+	type constructed struct {
+		decoder io.ReadCloser
+		err     error
+	}
+	result := make(chan constructed, 1)
+	go func() {
+		decoder, err := reader(input)
+		result <- constructed{decoder, err}
+	}()
+	timer := time.NewTimer(time.Until(d.deadline))
+	defer timer.Stop()
+	select {
+	case c := <-result:
+		return c.decoder, c.err
+	case <-timer.C:
+		return nil, &RequestTimeoutError{Timeout: d.timeout}
+	}
+}
@@ -0,0 +1,268 @@
+package requestbody
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stallingReader returns the prefix, then blocks until released.
+type stallingReader struct {
+	prefix  []byte
+	release chan struct{}
+}
+
+func (s *stallingReader) Read(p []byte) (int, error) {
+	if len(s.prefix) > 0 {
+		n := copy(p, s.prefix)
+		s.prefix = s.prefix[n:]
+		return n, nil
+	}
+	<-s.release
+	return 0, io.EOF
+}
+
+func TestInitTimeout(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("stalled gzip header", func(t *testing.T) {
+		t.Parallel()
+		stalling := &stallingReader{prefix: gzipBytes(t, sourceData)[:4], release: make(chan struct{})}
+		defer close(stalling.release)
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", stalling)
+		req.Header.Set("Content-Encoding", "gzip")
+
+		start := time.Now()
+		RequestBodyHandler(http.HandlerFunc(handler), InitTimeout(50*time.Millisecond), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+		var timeoutErr *RequestTimeoutError
+		assertEqual(t, true, errors.As(<-errs, &timeoutErr))
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected the timeout to stop construction promptly, took %v", elapsed)
+		}
+	})
+
+	t.Run("stalled gzip header from client", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), InitTimeout(50*time.Millisecond))
+		body, writer := io.Pipe()
+		defer writer.Close()
+		go func() {
+			_, _ = writer.Write(gzipBytes(t, sourceData)[:4])
+		}()
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		assertNoError(t, err)
+		req.Header.Set("Content-Encoding", "gzip")
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusRequestTimeout, response.StatusCode)
+	})
+
+	t.Run("within timeout", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), InitTimeout(time.Second))
+
+		response := postEncoded(t, ts, "gzip", gzipBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("within timeout without connection deadline", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, sourceData)))
+		req.Header.Set("Content-Encoding", "gzip")
+		response := httptest.NewRecorder()
+
+		RequestBodyHandler(http.HandlerFunc(echoHandler()), InitTimeout(time.Second)).ServeHTTP(response, req)
+
+		assertEqual(t, http.StatusOK, response.Code)
+		assertEqual(t, string(sourceData), response.Body.String())
+	})
+
+	t.Run("keeps server read timeout", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		ts := httptest.NewUnstartedServer(RequestBodyHandler(http.HandlerFunc(handler), InitTimeout(5*time.Second), ReturnOnError()))
+		ts.Config.ReadTimeout = 300 * time.Millisecond
+		ts.Start()
+		t.Cleanup(ts.Close)
+
+		body, writer := io.Pipe()
+		encoded := gzipBytes(t, sourceData)
+		go func() {
+			// Send enough for the decoder to be constructed, then stall beyond the server's ReadTimeout.
+			_, _ = writer.Write(encoded[:20])
+			time.Sleep(600 * time.Millisecond)
+			_, _ = writer.Write(encoded[20:])
+			_ = writer.Close()
+		}()
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		assertNoError(t, err)
+		req.Header.Set("Content-Encoding", "gzip")
+		if response, err := ts.Client().Do(req); err == nil {
+			response.Body.Close()
+		}
+
+		if err := <-errs; err == nil {
+			t.Errorf("Expected the server's ReadTimeout to stop the stalled body")
+		}
+	})
+}
+
+// tricklingReader returns one byte of the data at a time, waiting for the delay before each.
+type tricklingReader struct {
+	data  []byte
+	delay time.Duration
+}
+
+func (s *tricklingReader) Read(p []byte) (int, error) {
+	if len(s.data) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(s.delay)
+	n := copy(p[:1], s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+func TestReadTimeout(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+	serve := func(t *testing.T, req *http.Request, opts ...Option) (int64, error) {
+		t.Helper()
+		type result struct {
+			read int64
+			err  error
+		}
+		results := make(chan result, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			read, err := io.Copy(io.Discard, r.Body)
+			results <- result{read, err}
+		}
+		RequestBodyHandler(http.HandlerFunc(handler), append(opts, ReturnOnError())...).ServeHTTP(httptest.NewRecorder(), req)
+		got := <-results
+		return got.read, got.err
+	}
+
+	t.Run("trickling body", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", &tricklingReader{data: sourceData, delay: 10 * time.Millisecond})
+
+		read, err := serve(t, req, ReadTimeout(100*time.Millisecond))
+
+		var timeoutErr *RequestTimeoutError
+		assertEqual(t, true, errors.As(err, &timeoutErr))
+		assertEqual(t, 100*time.Millisecond, timeoutErr.Timeout)
+		if read == 0 || read >= int64(len(sourceData)) {
+			t.Errorf("Expected the body to be stopped part way, read %d bytes", read)
+		}
+	})
+
+	t.Run("large fast body", func(t *testing.T) {
+		t.Parallel()
+		large := bytes.Repeat(sourceData, 100_000)
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(gzipBytes(t, large)))
+		req.Header.Set("Content-Encoding", "gzip")
+
+		read, err := serve(t, req, ReadTimeout(5*time.Second), ContentLengthLimit(-1))
+
+		assertNoError(t, err)
+		assertEqual(t, int64(len(large)), read)
+	})
+
+	t.Run("reads after the end of the body", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.ReadAll(r.Body)
+			time.Sleep(100 * time.Millisecond)
+			_, err := r.Body.Read(make([]byte, 1))
+			errs <- err
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sourceData))
+		RequestBodyHandler(http.HandlerFunc(handler), ReadTimeout(50*time.Millisecond), ReturnOnError()).ServeHTTP(httptest.NewRecorder(), req)
+
+		assertEqual(t, io.EOF, <-errs)
+	})
+
+	t.Run("stalled client", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), ReadTimeout(100*time.Millisecond))
+		body, writer := io.Pipe()
+		defer writer.Close()
+		go func() {
+			_, _ = writer.Write(sourceData[:10])
+		}()
+
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		assertNoError(t, err)
+		start := time.Now()
+		response, err := ts.Client().Do(req)
+		assertNoError(t, err)
+		defer response.Body.Close()
+
+		assertEqual(t, http.StatusRequestTimeout, response.StatusCode)
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected the read deadline to stop the stalled read promptly, took %v", elapsed)
+		}
+	})
+
+	t.Run("stalled client with server read timeout", func(t *testing.T) {
+		t.Parallel()
+		errs := make(chan error, 1)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			_, err := io.ReadAll(r.Body)
+			errs <- err
+		}
+		ts := httptest.NewUnstartedServer(RequestBodyHandler(http.HandlerFunc(handler), ReadTimeout(50*time.Millisecond), ReturnOnError()))
+		ts.Config.ReadTimeout = 300 * time.Millisecond
+		ts.Start()
+		t.Cleanup(ts.Close)
+
+		body, writer := io.Pipe()
+		defer writer.Close()
+		go func() {
+			_, _ = writer.Write(sourceData[:10])
+		}()
+		req, err := http.NewRequest(http.MethodPost, ts.URL, body)
+		assertNoError(t, err)
+		if response, err := ts.Client().Do(req); err == nil {
+			response.Body.Close()
+		}
+
+		// The server's deadline ends the stalled read, leaving the body short.
+		if err := <-errs; err == nil {
+			t.Errorf("Expected the server's ReadTimeout to stop the stalled body")
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Parallel()
+		req := httptest.NewRequest(http.MethodPost, "/", &tricklingReader{data: sourceData[:5], delay: 10 * time.Millisecond})
+
+		read, err := serve(t, req)
+
+		assertNoError(t, err)
+		assertEqual(t, int64(5), read)
+	})
+}
@@ -0,0 +1,144 @@
+package requestbody
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// zstdMaxWindow bounds the memory a single request can make the decoder allocate for its window,
+// matching the 8MB limit required of zstd content-coding senders by RFC 9659.
+const zstdMaxWindow = 8 << 20
+
+const (
+	zstdFrameMagic         = 0xFD2FB528
+	zstdSkippableMagicMask = 0xFFFFFFF0
+	zstdSkippableMagic     = 0x184D2A50
+)
+
+// zstdReader creates the decoder on the first read, so the input can still be limited to a single
+// frame when the MultiFrame option is disabled.
+type zstdReader struct {
+	input   io.Reader
+	decoder io.ReadCloser
+}
+
+func (z *zstdReader) Read(p []byte) (int, error) {
+	if z.decoder == nil {
+		decoder, err := newZstdDecoder(z.input)
+		if err != nil {
+			return 0, err
+		}
+		z.decoder = decoder
+	}
+	return z.decoder.Read(p)
+}
+
+func (z *zstdReader) Close() error {
+	if z.decoder != nil {
+		return z.decoder.Close()
+	}
+	return nil
+}
+
+// zstdFrameReader passes through the first zstd frame, and any skippable frames before it, then
+// returns io.EOF leaving any following data unread. Malformed data is passed through as-is for the
+// decoder to reject.
+type zstdFrameReader struct {
+	reader    *bufio.Reader
+	remaining int
+	state     zstdFrameState
+	checksum  bool
+}
+
+type zstdFrameState int
+
+const (
+	zstdFrameStart zstdFrameState = iota
+	zstdFrameBlock
+	zstdFrameChecksum
+	zstdFrameDone
+	zstdFramePassthrough
+)
+
+func (f *zstdFrameReader) Read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		switch f.state {
+		case zstdFrameDone:
+			return 0, io.EOF
+		case zstdFramePassthrough:
+			return f.reader.Read(p)
+		}
+		f.next()
+	}
+	if len(p) > f.remaining {
+		p = p[:f.remaining]
+	}
+	n, err := f.reader.Read(p)
+	f.remaining -= n
+	return n, err
+}
+
+// next measures the next section of the frame from its header.
+func (f *zstdFrameReader) next() {
+	switch f.state {
+	case zstdFrameStart:
+		header, err := f.reader.Peek(8)
+		if len(header) < 5 {
+			f.state = zstdFramePassthrough
+			return
+		}
+		magic := binary.LittleEndian.Uint32(header)
+		if magic&zstdSkippableMagicMask == zstdSkippableMagic {
+			if err != nil {
+				f.state = zstdFramePassthrough
+				return
+			}
+			f.remaining = 8 + int(binary.LittleEndian.Uint32(header[4:]))
+			return
+		}
+		if magic != zstdFrameMagic {
+			f.state = zstdFramePassthrough
+			return
+		}
+		descriptor := header[4]
+		singleSegment := descriptor&0x20 != 0
+		f.checksum = descriptor&0x04 != 0
+		length := 5 + [4]int{0, 1, 2, 4}[descriptor&0x03]
+		if !singleSegment {
+			length++ // Window descriptor.
+		}
+		switch fcs := descriptor >> 6; {
+		case fcs == 0 && singleSegment:
+			length++
+		case fcs > 0:
+			length += 1 << fcs
+		}
+		f.remaining = length
+		f.state = zstdFrameBlock
+	case zstdFrameBlock:
+		header, _ := f.reader.Peek(3)
+		if len(header) < 3 {
+			f.state = zstdFramePassthrough
+			return
+		}
+		blockHeader := uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16
+		size := int(blockHeader >> 3)
+		switch blockType := (blockHeader >> 1) & 0x03; blockType {
+		case 1: // An RLE block has a single byte of content.
+			size = 1
+		case 3: // Reserved, so leave it to the decoder to reject.
+			f.state = zstdFramePassthrough
+			return
+		}
+		f.remaining = 3 + size
+		if blockHeader&0x01 != 0 {
+			f.state = zstdFrameChecksum
+		}
+	case zstdFrameChecksum:
+		f.state = zstdFrameDone
+		if f.checksum {
+			f.remaining = 4
+		}
+	}
+}
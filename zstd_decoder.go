@@ -0,0 +1,33 @@
+//go:build !requestbody_nozstd
+
+package requestbody
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdAvailable reports whether the Zstandard decoder is compiled in, which can be disabled using
+// the requestbody_nozstd build tag.
+const zstdAvailable = true
+
+// ZstdEncodingReader decodes a Zstandard ("zstd") encoded body. It's supported by default.
+//
+// Frames requiring a window larger than 8MB are rejected to bound the memory used per request.
+// Concatenated frames are all decoded unless disabled using the MultiFrame option.
+// Closing the reader releases the decoder's resources.
+func ZstdEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return &zstdReader{input: r}, nil
+}
+
+func newZstdDecoder(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(zstdMaxWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
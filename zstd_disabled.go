@@ -0,0 +1,21 @@
+//go:build requestbody_nozstd
+
+package requestbody
+
+import (
+	"io"
+)
+
+// zstdAvailable reports whether the Zstandard decoder is compiled in, which has been disabled using
+// the requestbody_nozstd build tag.
+const zstdAvailable = false
+
+// ZstdEncodingReader rejects Zstandard ("zstd") encoded bodies with a RequestUnsupportedMediaTypeError,
+// as the decoder was excluded using the requestbody_nozstd build tag.
+func ZstdEncodingReader(r io.Reader) (io.ReadCloser, error) {
+	return nil, unavailableEncodingError("zstd")
+}
+
+func newZstdDecoder(r io.Reader) (io.ReadCloser, error) {
+	return nil, unavailableEncodingError("zstd")
+}
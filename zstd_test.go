@@ -0,0 +1,118 @@
+//go:build !requestbody_nozstd
+
+package requestbody
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestZstdEncodingReader(t *testing.T) {
+	t.Parallel()
+
+	sourceData := []byte("The quick brown fox jumps over the lazy dog")
+
+	t.Run("supported by default", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "zstd", zstdBytes(t, sourceData))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("chained with other encodings", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+		encoded := gzipBytes(t, zstdBytes(t, deflateBytes(t, sourceData)))
+
+		response := postEncoded(t, ts, "deflate, zstd, gzip", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData), readString(t, response))
+	})
+
+	t.Run("concatenated frames", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+		encoded := append(zstdBytes(t, sourceData), zstdBytes(t, sourceData)...)
+
+		response := postEncoded(t, ts, "zstd", encoded)
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(sourceData)+string(sourceData), readString(t, response))
+	})
+
+	t.Run("concatenated frames rejected", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("zstd", false))
+		encoded := append(zstdBytes(t, sourceData), zstdBytes(t, sourceData)...)
+
+		response := postEncoded(t, ts, "zstd", encoded)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("single frame allowed", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler(), MultiFrame("zstd", false))
+		large := bytes.Repeat(sourceData, 10000)
+
+		response := postEncoded(t, ts, "zstd", zstdBytes(t, large, zstd.WithEncoderCRC(true)))
+
+		assertEqual(t, http.StatusOK, response.StatusCode)
+		assertEqual(t, string(large), readString(t, response))
+	})
+
+	t.Run("window too large", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+		encoded := zstdBytes(t, sourceData, zstd.WithSingleSegment(false))
+		// The encoder shrinks the window to fit small inputs, so declare a 16MB window in the header.
+		assertEqual(t, byte(0x04), encoded[4])
+		encoded[5] = 14 << 3
+
+		response := postEncoded(t, ts, "zstd", encoded)
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		t.Parallel()
+		ts := setupServer(t, echoHandler())
+
+		response := postEncoded(t, ts, "zstd", []byte("not zstd"))
+
+		assertEqual(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("close releases decoder", func(t *testing.T) {
+		t.Parallel()
+
+		reader, err := ZstdEncodingReader(bytes.NewReader(zstdBytes(t, sourceData)))
+		assertNoError(t, err)
+		decoded, err := io.ReadAll(reader)
+		assertNoError(t, err)
+		assertEqual(t, sourceData, decoded)
+		assertNoError(t, reader.Close())
+		_, err = reader.Read(make([]byte, 1))
+		assertEqual(t, zstd.ErrDecoderClosed, err)
+	})
+}
+
+func zstdBytes(t *testing.T, data []byte, opts ...zstd.EOption) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	encoder, err := zstd.NewWriter(&buf, opts...)
+	assertNoError(t, err)
+	_, err = encoder.Write(data)
+	assertNoError(t, err)
+	assertNoError(t, encoder.Close())
+	return buf.Bytes()
+}